@@ -49,16 +49,17 @@ func main() {
 	// normal
 	consumer := redis_mq.NewSimpleMQConsumer(ctx, client, topicName)
 	// use LBPop
-	//consumer := redis_mq.NewSimpleMQConsumer(ctx, client, topicName, redis_mq.UseBLPop(true), redis_mq.NewRateLimitPeriod(time.Millisecond*100))
+	//consumer := redis_mq.NewSimpleMQConsumer(ctx, client, topicName, redis_mq.UseBLPop(true))
 	consumer.SetHandler(&MyHandler{})
 
+	produceCtx, stopProduce := context.WithCancel(ctx)
 	go func() {
 		ticker := time.NewTicker(time.Second / 10)
 		producer := redis_mq.NewProducer(client)
 		defer ticker.Stop()
 		for {
 			select {
-			case <-ctx.Done():
+			case <-produceCtx.Done():
 				fmt.Println("stop produce...")
 				return
 			case <-ticker.C:
@@ -78,9 +79,16 @@ func main() {
 		}
 	}()
 
-	stopCh := make(chan os.Signal)
+	stopCh := make(chan os.Signal, 1)
 	signal.Notify(stopCh, os.Interrupt)
 	<-stopCh
+	stopProduce()
+	// Shutdown lets the handlers finish, cancelling ctx first would cancel them right away
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer shutdownCancel()
+	if err := consumer.Shutdown(shutdownCtx); err != nil {
+		fmt.Printf("shutdown error: %#v \n", err)
+	}
 	cancel()
 	fmt.Println("stop server")
 }
@@ -0,0 +1,173 @@
+package redis_mq
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// BatchHandler receives up to the batch size messages per fetch.
+// A nil error acks all messages, an error retries all of them.
+type BatchHandler interface {
+	HandleMessages(msgs []*Message) error
+}
+
+// SetBatchHandler sets a handler that receives up to size messages at once.
+// Messages are fetched from the lists with one lua script (LRANGE + LTRIM), UseBLPop is ignored.
+// Like SetHandler it replaces the previous handler of any kind.
+func (s *consumer) SetBatchHandler(handler BatchHandler, size int) {
+	s.setBatchHandler(handler, size)
+	s.start()
+}
+
+func (s *consumer) setBatchHandler(handler BatchHandler, size int) {
+	if size < 1 {
+		size = 1
+	}
+	s.handlerMu.Lock()
+	defer s.handlerMu.Unlock()
+	s.handler = nil
+	s.errHandler = nil
+	s.batchHandler = handler
+	s.batchSize = size
+	s.chain.Store(s.buildHandler())
+}
+
+// batchFetchScript pops up to ARGV[1] messages from the (list, unack) pairs in KEYS, in order.
+// When ARGV[2] is set the messages are recorded in the unack zset with ARGV[2] as score.
+// It returns {index, message, index, message, ...}.
+var batchFetchScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+local rev = {}
+for i = 1, #KEYS, 2 do
+	if n <= 0 then
+		break
+	end
+	local msgs = redis.call('LRANGE', KEYS[i], 0, n - 1)
+	if #msgs > 0 then
+		redis.call('LTRIM', KEYS[i], #msgs, -1)
+		for _, v in ipairs(msgs) do
+			if ARGV[2] ~= '' then
+				redis.call('ZADD', KEYS[i + 1], ARGV[2], v)
+			end
+			table.insert(rev, (i - 1) / 2)
+			table.insert(rev, v)
+		end
+		n = n - #msgs
+	end
+end
+return rev
+`)
+
+type fetchedMessage struct {
+	raw   string
+	level int
+}
+
+func (s *consumer) fetchBatch(limit int) ([]fetchedMessage, error) {
+	levels := s.priorityLevels()
+	keys := make([]string, 0, levels*2)
+	for level := levels - 1; level >= 0; level-- {
+		keys = append(keys, s.listKey(level), s.unackKey(level))
+	}
+	deadline := ""
+	if s.options.Reliable {
+		deadline = strconv.FormatInt(unixMilli(time.Now().Add(s.options.VisibilityTimeout)), 10)
+	}
+	v, err := batchFetchScript.Run(s.redisCmd, keys, limit, deadline).Result()
+	if err != nil {
+		return nil, err
+	}
+	values, _ := v.([]interface{})
+	if len(values) == 0 {
+		return nil, redis.Nil
+	}
+	rev := make([]fetchedMessage, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		index, _ := values[i].(int64)
+		raw, _ := values[i+1].(string)
+		rev = append(rev, fetchedMessage{raw: raw, level: levels - 1 - int(index)})
+	}
+	return rev, nil
+}
+
+func (s *consumer) dispatchBatch(handler BatchHandler, msgs []*Message) {
+	batch := make([]*Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Expired() || !s.claimDedup(msg) {
+			msg.Ack()
+			continue
+		}
+		batch = append(batch, msg)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	start := time.Now()
+	err := handler.HandleMessages(batch)
+	s.options.Metrics.Handled(s.topicName, len(batch), time.Since(start), err)
+	if err != nil {
+		for _, msg := range batch {
+			s.releaseDedup(msg)
+			s.retryOrRelease(msg, err)
+		}
+		return
+	}
+	for _, msg := range batch {
+		s.completeDedup(msg)
+		msg.Ack()
+	}
+}
+
+// PublishBatch publishes several messages with one round trip, using a multi-value RPUSH
+// per list (or pipelined XADDs). The publish options apply to every message.
+// With UseProducerDedup the messages are published one by one.
+func (p *Producer) PublishBatch(topicName string, bodies [][]byte, opts ...PublishOption) error {
+	err := p.publishBatch(topicName, bodies, opts)
+	p.observePublish(topicName, len(bodies), err)
+	return p.reportError("publish batch", topicName, "", err)
+}
+
+func (p *Producer) publishBatch(topicName string, bodies [][]byte, opts []PublishOption) error {
+	msgs := make([]*Message, 0, len(bodies))
+	for _, body := range bodies {
+		msgs = append(msgs, p.newPublishMessage(body, opts))
+	}
+	if p.options.DedupWindow > 0 {
+		for _, msg := range msgs {
+			msg := msg
+			if err := p.publishOnce(topicName, msg.ID, func() error { return p.push(topicName, msg) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var order []string
+	values := make(map[string][]interface{})
+	for _, msg := range msgs {
+		sendData, err := encodeMessage(p.options.Codec, msg)
+		if err != nil {
+			return err
+		}
+		key := p.listTarget(topicName, msg)
+		if _, ok := values[key]; !ok {
+			order = append(order, key)
+		}
+		values[key] = append(values[key], sendData)
+	}
+	_, err := p.redisCmd.Pipelined(func(pip redis.Pipeliner) error {
+		for _, key := range order {
+			vs := values[key]
+			if p.options.UseStream {
+				for _, v := range vs {
+					pip.XAdd(p.xaddArgs(topicName, v.(string)))
+				}
+				continue
+			}
+			pip.RPush(key, vs...)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,126 @@
+package redis_mq
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchBatch(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		// two messages of topic:list:2, one of topic:list
+		return []interface{}{int64(0), "a", int64(0), "b", int64(2), "c"}
+	})
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic", UsePriorityLevels(3), UseReliable(time.Minute))
+	msgs, err := s.fetchBatch(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []fetchedMessage{{raw: "a", level: 2}, {raw: "b", level: 2}, {raw: "c", level: 0}}
+	if len(msgs) != len(want) {
+		t.Fatalf("msgs %+v", msgs)
+	}
+	for i := range want {
+		if msgs[i] != want[i] {
+			t.Fatalf("msgs %+v", msgs)
+		}
+	}
+	keys, argv := evalArgs(f.commands("EVAL")[0])
+	if strings.Join(keys, ",") != "topic:list:2,topic:unack:2,topic:list:1,topic:unack:1,topic:list,topic:unack" {
+		t.Fatalf("keys %q", keys)
+	}
+	if argv[0] != "10" || argv[1] == "" {
+		t.Fatalf("argv %q", argv)
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return 1
+	})
+	defer f.Close()
+
+	p := NewProducer(client, UseProducerPriorityLevels(2))
+	if err := p.PublishBatch("topic", [][]byte{[]byte("a"), []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PublishBatch("topic", [][]byte{[]byte("c")}, WithPriority(1)); err != nil {
+		t.Fatal(err)
+	}
+	// one RPUSH per list, in publish order
+	pushes := f.commands("RPUSH")
+	if len(pushes) != 2 || pushes[0][1] != "topic:list" || len(pushes[0]) != 4 || pushes[1][1] != "topic:list:1" {
+		t.Fatalf("pushes %q", pushes)
+	}
+	for i, body := range []string{"a", "b"} {
+		msg := &Message{}
+		if err := decodeMessage(JSONCodec, []byte(pushes[0][2+i]), msg); err != nil || string(msg.Body) != body {
+			t.Fatalf("msg %+v %v", msg, err)
+		}
+	}
+}
+
+type batchHandlerFunc func(msgs []*Message) error
+
+func (f batchHandlerFunc) HandleMessages(msgs []*Message) error {
+	return f(msgs)
+}
+
+func TestChangeHandlerAfterStart(t *testing.T) {
+	raw, _ := encodeMessage(JSONCodec, NewMessage("id", []byte("body")))
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		switch args[0] {
+		case "LPOP":
+			return raw
+		case "EVAL":
+			if keys, _ := evalArgs(args); keys[0] == "topic:list" {
+				// batch fetch
+				return []interface{}{int64(0), raw}
+			}
+			return []interface{}{int64(0), ""}
+		}
+		return 1
+	})
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic")
+	defer s.Shutdown(context.Background())
+	handled := make(chan string, 100)
+	send := func(name string) {
+		select {
+		case handled <- name:
+		default:
+		}
+	}
+	s.SetHandler(handlerFunc(func(msg *Message) {
+		send("handler")
+	}))
+	waitHandled := func(want string) {
+		deadline := time.After(time.Second)
+		for {
+			select {
+			case got := <-handled:
+				if got == want {
+					return
+				}
+			case <-deadline:
+				t.Fatalf("%s not called", want)
+			}
+		}
+	}
+	waitHandled("handler")
+	s.SetBatchHandler(batchHandlerFunc(func(msgs []*Message) error {
+		send("batch")
+		return nil
+	}), 10)
+	waitHandled("batch")
+	// a later handler replaces the batch handler
+	s.SetErrorHandler(HandlerFunc(func(msg *Message) error {
+		send("error handler")
+		return nil
+	}))
+	waitHandled("error handler")
+}
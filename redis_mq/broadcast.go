@@ -0,0 +1,76 @@
+package redis_mq
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const broadcastSuffix = ":broadcast"
+
+// Broadcast publishes a message to every broadcast consumer of the topic that is
+// subscribed right now, over the <topic>:broadcast pub/sub channel.
+func (p *Producer) Broadcast(topicName string, body []byte, opts ...PublishOption) error {
+	msg := p.newPublishMessage(body, opts)
+	sendData, err := encodeMessage(p.options.Codec, msg)
+	if err == nil {
+		err = p.redisCmd.Publish(topicName+broadcastSuffix, sendData).Err()
+	}
+	p.observePublish(topicName, 1, err)
+	return p.reportError("broadcast", topicName, msg.ID, err)
+}
+
+// NewBroadcastConsumer subscribes to the broadcast messages of the topic, every broadcast
+// consumer gets every message. Delivery is at most once: there is no ack or retry, handler
+// errors are only reported, and messages broadcast while the consumer is not connected are
+// lost. For durable fan-out use stream consumers with a group per instance instead.
+func NewBroadcastConsumer(ctx context.Context, client redis.UniversalClient, topicName string, opts ...ConsumerOption) Consumer {
+	opts = append(opts, func(o *ConsumerOptions) {
+		o.Reliable = false
+		o.StreamGroup = ""
+	})
+	s := NewSimpleMQConsumer(ctx, client, topicName, opts...)
+	s.subscriber = client
+	return s
+}
+
+func (s *consumer) broadcast() bool {
+	return s.subscriber != nil
+}
+
+func (s *consumer) startBroadcast() {
+	pubsub := s.subscriber.Subscribe(s.topicName + broadcastSuffix)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.options.Logger.Printf("stop broadcast subscription.")
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+				s.options.Metrics.Fetched(s.topicName, 1)
+				if msg := s.newBroadcastMessage(m.Payload); msg != nil {
+					s.deliver(msg)
+				}
+			}
+		}
+	}()
+}
+
+func (s *consumer) newBroadcastMessage(raw string) *Message {
+	msg := &Message{}
+	if err := decodeMessage(s.options.Codec, []byte(raw), msg); err != nil {
+		s.reportError("decode", "", err)
+		return nil
+	}
+	msg.topic = s.topicName
+	msg.fetchedAt = time.Now()
+	return msg
+}
@@ -0,0 +1,32 @@
+package redis_mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func TestBroadcastMessage(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer client.Close()
+	var reported []error
+	s := NewBroadcastConsumer(context.Background(), client, "topic", UseReliable(0),
+		OnError(func(err error) { reported = append(reported, err) }))
+	if s.options.Reliable || s.processingTimeout() != 0 {
+		t.Fatal("broadcast consumers are not reliable")
+	}
+
+	raw, _ := encodeMessage(JSONCodec, NewMessage("id", []byte("body")))
+	msg := s.newBroadcastMessage(raw)
+	if msg == nil || msg.ID != "id" || msg.Topic() != "topic" {
+		t.Fatalf("msg %#v", msg)
+	}
+	// no retry and no release, the message only exists in this consumer
+	s.retry(msg, errors.New("failed"))
+	s.release(msg)
+	if len(reported) != 1 || reported[0].(*Error).Op != "handle" {
+		t.Fatalf("reported %v", reported)
+	}
+}
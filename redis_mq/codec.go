@@ -0,0 +1,224 @@
+package redis_mq
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+// Codec serializes messages for redis.
+//
+// Consumers detect the built-in formats by their first byte ('{' for JSONCodec, the
+// version byte for BinaryCodec), so consumers read both formats whatever codec they use,
+// and producers can switch codecs once all consumers are upgraded. Data in other
+// formats is decoded with the codec the consumer was configured with.
+type Codec interface {
+	Encode(msg *Message) ([]byte, error)
+	Decode(data []byte, msg *Message) error
+}
+
+var (
+	// JSONCodec is the default codec and the original wire format.
+	JSONCodec Codec = jsonCodec{}
+	// BinaryCodec is a compact length-prefixed format that does not base64 the body.
+	BinaryCodec Codec = binaryCodec{}
+)
+
+var errInvalidBinaryMessage = errors.New("invalid binary message")
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(msg *Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Decode(data []byte, msg *Message) error {
+	return json.Unmarshal(data, msg)
+}
+
+// binary format, version 1:
+//
+//	version byte
+//	id        uvarint length + bytes
+//	body      uvarint length + bytes
+//	timestamp varint
+//	delayTime varint
+//	fields    (uvarint tag, uvarint length, bytes)...
+//
+// Optional fields are tagged, decoders skip tags they do not know.
+const binaryCodecVersion byte = 1
+
+const (
+	binaryTagAttempts uint64 = iota + 1
+	binaryTagHeaders
+	binaryTagExpireAt
+	binaryTagPriority
+)
+
+type binaryCodec struct{}
+
+func (binaryCodec) Encode(msg *Message) ([]byte, error) {
+	buf := make([]byte, 0, 1+len(msg.ID)+len(msg.Body)+4*binary.MaxVarintLen64)
+	buf = append(buf, binaryCodecVersion)
+	buf = appendBytes(buf, []byte(msg.ID))
+	buf = appendBytes(buf, msg.Body)
+	buf = appendVarint(buf, msg.Timestamp)
+	buf = appendVarint(buf, msg.DelayTime)
+	if msg.Attempts != 0 {
+		buf = appendUvarint(buf, binaryTagAttempts)
+		buf = appendBytes(buf, appendUvarint(nil, uint64(msg.Attempts)))
+	}
+	if len(msg.Headers) > 0 {
+		field := appendUvarint(nil, uint64(len(msg.Headers)))
+		for k, v := range msg.Headers {
+			field = appendBytes(field, []byte(k))
+			field = appendBytes(field, []byte(v))
+		}
+		buf = appendUvarint(buf, binaryTagHeaders)
+		buf = appendBytes(buf, field)
+	}
+	if msg.ExpireAt != 0 {
+		buf = appendUvarint(buf, binaryTagExpireAt)
+		buf = appendBytes(buf, appendVarint(nil, msg.ExpireAt))
+	}
+	if msg.Priority != 0 {
+		buf = appendUvarint(buf, binaryTagPriority)
+		buf = appendBytes(buf, appendVarint(nil, int64(msg.Priority)))
+	}
+	return buf, nil
+}
+
+func (binaryCodec) Decode(data []byte, msg *Message) error {
+	if len(data) == 0 || data[0] != binaryCodecVersion {
+		return errInvalidBinaryMessage
+	}
+	r := &binaryReader{data: data[1:]}
+	msg.ID = string(r.bytes())
+	msg.Body = r.bytes()
+	msg.Timestamp = r.varint()
+	msg.DelayTime = r.varint()
+	for r.err == nil && len(r.data) > 0 {
+		tag := r.uvarint()
+		field := &binaryReader{data: r.bytes()}
+		switch tag {
+		case binaryTagAttempts:
+			msg.Attempts = int(field.uvarint())
+		case binaryTagHeaders:
+			n := field.uvarint()
+			if n > uint64(len(field.data)) {
+				return errInvalidBinaryMessage
+			}
+			msg.Headers = make(map[string]string, n)
+			for i := uint64(0); i < n && field.err == nil; i++ {
+				k := field.bytes()
+				msg.Headers[string(k)] = string(field.bytes())
+			}
+		case binaryTagExpireAt:
+			msg.ExpireAt = field.varint()
+		case binaryTagPriority:
+			msg.Priority = int(field.varint())
+		}
+		if field.err != nil {
+			return field.err
+		}
+	}
+	return r.err
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errInvalidBinaryMessage
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errInvalidBinaryMessage
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) bytes() []byte {
+	l := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if l > uint64(len(r.data)) {
+		r.err = errInvalidBinaryMessage
+		return nil
+	}
+	b := r.data[:l:l]
+	r.data = r.data[l:]
+	return b
+}
+
+// decodeMessage decodes data in any of the built-in formats, other data with c.
+func decodeMessage(c Codec, data []byte, msg *Message) error {
+	if len(data) > 0 {
+		switch data[0] {
+		case '{':
+			return JSONCodec.Decode(data, msg)
+		case binaryCodecVersion:
+			return BinaryCodec.Decode(data, msg)
+		}
+	}
+	return c.Decode(data, msg)
+}
+
+func encodeMessage(c Codec, msg *Message) (string, error) {
+	data, err := c.Encode(msg)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// UseCodec sets the codec used to encode retried and released messages,
+// and to decode messages that are not in a built-in format.
+func UseCodec(c Codec) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Codec = c
+	}
+}
+
+// UseProducerCodec sets the codec used to encode published messages, JSONCodec by default.
+func UseProducerCodec(c Codec) ProducerOption {
+	return func(o *ProducerOptions) {
+		o.Codec = c
+	}
+}
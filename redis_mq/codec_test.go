@@ -0,0 +1,51 @@
+package redis_mq
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	msg := NewMessage("", []byte(`{"name":"abc"}`))
+	msg.Attempts = 3
+	msg.Headers = map[string]string{"traceId": "abc", "contentType": "application/json"}
+	msg.ExpireAt = 1586900000000
+	msg.Priority = 2
+	for _, c := range []Codec{JSONCodec, BinaryCodec} {
+		data, err := c.Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rev := &Message{}
+		if err := decodeMessage(JSONCodec, data, rev); err != nil {
+			t.Fatal(err)
+		}
+		if rev.ID != msg.ID || !bytes.Equal(rev.Body, msg.Body) || rev.Timestamp != msg.Timestamp ||
+			rev.DelayTime != msg.DelayTime || rev.Attempts != msg.Attempts ||
+			!reflect.DeepEqual(rev.Headers, msg.Headers) || rev.ExpireAt != msg.ExpireAt || rev.Priority != msg.Priority {
+			t.Errorf("%T: got %#v want %#v", c, rev, msg)
+		}
+	}
+}
+
+func TestBinaryCodecSmaller(t *testing.T) {
+	msg := NewMessage("", bytes.Repeat([]byte("a"), 300))
+	jsonData, _ := JSONCodec.Encode(msg)
+	binaryData, _ := BinaryCodec.Encode(msg)
+	if len(binaryData) >= len(jsonData)*3/4 {
+		t.Errorf("binary %d bytes, json %d bytes", len(binaryData), len(jsonData))
+	}
+}
+
+func TestBinaryCodecInvalid(t *testing.T) {
+	data, _ := BinaryCodec.Encode(NewMessage("", []byte("body")))
+	for i := 1; i < len(data); i++ {
+		if err := BinaryCodec.Decode(data[:i], &Message{}); err == nil {
+			t.Errorf("decode %d bytes: expected error", i)
+		}
+	}
+	if err := decodeMessage(JSONCodec, []byte("not a message"), &Message{}); err == nil {
+		t.Error("expected error")
+	}
+}
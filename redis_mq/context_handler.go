@@ -0,0 +1,66 @@
+package redis_mq
+
+import (
+	"context"
+	"time"
+)
+
+// ContextHandler is an ErrorHandler that receives the context of the message.
+// The context is cancelled when the processing deadline passes, when the context of the consumer
+// is cancelled, or when Shutdown gives up waiting for the handler.
+type ContextHandler interface {
+	HandleMessage(ctx context.Context, msg *Message) error
+}
+
+// ContextHandlerFunc adapts a function to a ContextHandler.
+type ContextHandlerFunc func(ctx context.Context, msg *Message) error
+
+func (f ContextHandlerFunc) HandleMessage(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// SetContextHandler sets a handler taking the message context, the returned error drives
+// ack and retry like SetErrorHandler.
+func (s *consumer) SetContextHandler(handler ContextHandler) {
+	s.SetErrorHandler(HandlerFunc(func(msg *Message) error {
+		return handler.HandleMessage(msg.Context(), msg)
+	}))
+}
+
+// UseProcessingTimeout sets the deadline of the message context, counted from when the
+// message was fetched. Reliable and stream consumers cap it at the visibility timeout, so
+// the handler is cancelled before the message can be delivered to another consumer.
+func UseProcessingTimeout(d time.Duration) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.ProcessingTimeout = d
+	}
+}
+
+func (s *consumer) processingTimeout() time.Duration {
+	d := s.options.ProcessingTimeout
+	if s.options.Reliable || s.useStream() {
+		if d <= 0 || d > s.options.VisibilityTimeout {
+			d = s.options.VisibilityTimeout
+		}
+	}
+	return d
+}
+
+// withContext sets the context of the message before it is handled: the handler context of
+// the consumer, with the extracted trace context and the processing deadline.
+func (s *consumer) withContext(msg *Message) {
+	ctx := s.handlerCtx
+	if s.options.Propagator != nil {
+		ctx = s.options.Propagator.Extract(ctx, HeaderCarrier(msg.Headers))
+	}
+	if d := s.processingTimeout(); d > 0 && !msg.fetchedAt.IsZero() {
+		ctx, msg.cancel = context.WithDeadline(ctx, msg.fetchedAt.Add(d))
+	}
+	msg.ctx = ctx
+}
+
+func (m *Message) done() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+}
@@ -0,0 +1,36 @@
+package redis_mq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestProcessingTimeout(t *testing.T) {
+	s := NewSimpleMQConsumer(context.Background(), nil, "topic", UseReliable(time.Second), UseProcessingTimeout(time.Minute))
+	if d := s.processingTimeout(); d != time.Second {
+		t.Fatalf("timeout %v, want the visibility timeout", d)
+	}
+	fetchedAt := time.Now()
+	msg := &Message{fetchedAt: fetchedAt}
+	s.withContext(msg)
+	defer msg.done()
+	deadline, ok := msg.Context().Deadline()
+	if !ok || !deadline.Equal(fetchedAt.Add(time.Second)) {
+		t.Fatalf("deadline %v %v", deadline, ok)
+	}
+
+	s.handlerCancel()
+	select {
+	case <-msg.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("message context not cancelled with the handlers of the consumer")
+	}
+
+	s = NewSimpleMQConsumer(context.Background(), nil, "topic")
+	msg = &Message{fetchedAt: fetchedAt}
+	s.withContext(msg)
+	if _, ok := msg.Context().Deadline(); ok {
+		t.Fatal("unexpected deadline without a processing timeout")
+	}
+}
@@ -0,0 +1,40 @@
+package redis_mq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	base := time.Date(2020, 4, 15, 10, 30, 20, 0, time.UTC) // wednesday
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2020, 4, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 4, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17/2 * * *", time.Date(2020, 4, 15, 11, 0, 0, 0, time.UTC)},
+		{"30 8 * * 1,5", time.Date(2020, 4, 17, 8, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2020, 4, 19, 12, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2020, 4, 16, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		cron, err := parseCron(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+		if got := cron.Next(base); !got.Equal(c.next) {
+			t.Errorf("%s: got %v want %v", c.spec, got, c.next)
+		}
+	}
+}
+
+func TestParseCronError(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package redis_mq
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const deadSuffix = ":dead"
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a message that could not be processed, stored in the <topic>:dead list.
+// Payload is the raw message as it was read from redis.
+type DeadLetter struct {
+	ID        string `json:"id,omitempty"`
+	Payload   []byte `json:"payload"`
+	Reason    string `json:"reason"`
+	Attempts  int    `json:"attempts"`
+	Timestamp int64  `json:"timestamp"`
+	raw       string
+}
+
+// Message decodes the payload of the dead letter, which must be encoded by one of the built-in codecs.
+func (d *DeadLetter) Message() (*Message, error) {
+	return d.decode(JSONCodec)
+}
+
+func (d *DeadLetter) decode(codec Codec) (*Message, error) {
+	msg := &Message{}
+	if err := decodeMessage(codec, d.Payload, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// requeueDeadScript removes one dead letter and pushes its payload back to the list, or adds it
+// to the stream when ARGV[3] (the stream value key) is set, so concurrent requeues never deliver
+// the same dead letter twice.
+var requeueDeadScript = redis.NewScript(`
+redis.replicate_commands()
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 1 then
+	if ARGV[3] ~= '' then
+		redis.call('XADD', KEYS[2], '*', ARGV[3], ARGV[2])
+	else
+		redis.call('RPUSH', KEYS[2], ARGV[2])
+	end
+	return 1
+end
+return 0
+`)
+
+type DeadLetterQueue struct {
+	redisCmd  redis.Cmdable
+	topicName string
+	codec     Codec
+	stream    bool
+	levels    int
+	_         struct{}
+}
+
+// NewDeadLetterQueue returns the dead letter queue of a list topic. Dead letters of stream topics
+// are requeued into the stream by the queues of Producer.DeadLetterQueue and Consumer.DeadLetterQueue.
+func NewDeadLetterQueue(cmd redis.Cmdable, topicName string) *DeadLetterQueue {
+	return &DeadLetterQueue{redisCmd: cmd, topicName: topicName, codec: JSONCodec, levels: 1}
+}
+
+func (p *Producer) DeadLetterQueue(topicName string) *DeadLetterQueue {
+	q := NewDeadLetterQueue(p.redisCmd, topicName)
+	q.codec = p.options.Codec
+	q.stream = p.options.UseStream
+	q.levels = p.priorityLevels()
+	return q
+}
+
+func (s *consumer) DeadLetterQueue() *DeadLetterQueue {
+	q := NewDeadLetterQueue(s.redisCmd, s.topicName)
+	q.codec = s.options.Codec
+	q.stream = s.useStream()
+	q.levels = s.priorityLevels()
+	return q
+}
+
+func (q *DeadLetterQueue) key() string {
+	return q.topicName + deadSuffix
+}
+
+func (q *DeadLetterQueue) Len() (int64, error) {
+	return q.redisCmd.LLen(q.key()).Result()
+}
+
+// List returns the dead letters between start and stop (inclusive, LRANGE semantics).
+func (q *DeadLetterQueue) List(start, stop int64) ([]*DeadLetter, error) {
+	values, err := q.redisCmd.LRange(q.key(), start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	rev := make([]*DeadLetter, 0, len(values))
+	for _, v := range values {
+		d := &DeadLetter{}
+		if err := json.Unmarshal([]byte(v), d); err != nil {
+			return nil, err
+		}
+		d.raw = v
+		rev = append(rev, d)
+	}
+	return rev, nil
+}
+
+// Get returns the dead letter of the message id.
+func (q *DeadLetterQueue) Get(id string) (*DeadLetter, error) {
+	letters, err := q.List(0, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range letters {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+	return nil, ErrDeadLetterNotFound
+}
+
+// Requeue publishes the dead letter of the message id to the topic again, with its attempts reset.
+func (q *DeadLetterQueue) Requeue(id string) error {
+	d, err := q.Get(id)
+	if err != nil {
+		return err
+	}
+	ok, err := q.requeue(d)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// RequeueAll publishes all dead letters to the topic again and returns how many were requeued.
+func (q *DeadLetterQueue) RequeueAll() (int64, error) {
+	letters, err := q.List(0, -1)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, d := range letters {
+		ok, err := q.requeue(d)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (q *DeadLetterQueue) requeue(d *DeadLetter) (bool, error) {
+	payload, level := string(d.Payload), 0
+	if msg, err := d.decode(q.codec); err == nil {
+		msg.Attempts = 0
+		if sendData, err := encodeMessage(q.codec, msg); err == nil {
+			payload = sendData
+		}
+		level = priorityLevel(msg.Priority, q.levels)
+	}
+	target, valueKey := priorityKey(q.topicName+listSuffix, level), ""
+	if q.stream {
+		target, valueKey = q.topicName+streamSuffix, streamValueKey
+	}
+	n, err := requeueDeadScript.Run(q.redisCmd, []string{q.key(), target}, d.raw, payload, valueKey).Int64()
+	return n == 1, err
+}
+
+// Remove deletes the dead letter of the message id.
+func (q *DeadLetterQueue) Remove(id string) error {
+	d, err := q.Get(id)
+	if err != nil {
+		return err
+	}
+	return q.redisCmd.LRem(q.key(), 1, d.raw).Err()
+}
+
+// Purge deletes all dead letters of the topic.
+func (q *DeadLetterQueue) Purge() error {
+	return q.redisCmd.Del(q.key()).Err()
+}
+
+func encodeDeadLetter(id string, payload []byte, attempts int, reason string) string {
+	d := &DeadLetter{
+		ID:        id,
+		Payload:   payload,
+		Reason:    reason,
+		Attempts:  attempts,
+		Timestamp: time.Now().Unix(),
+	}
+	sendData, _ := json.Marshal(d)
+	return string(sendData)
+}
+
+func (s *consumer) deadLetter(id string, payload []byte, attempts int, reason string) error {
+	if err := s.redisCmd.RPush(s.topicName+deadSuffix, encodeDeadLetter(id, payload, attempts, reason)).Err(); err != nil {
+		return err
+	}
+	s.options.Metrics.DeadLettered(s.topicName)
+	return nil
+}
+
+// deadLetterUndecodable moves a payload that is not a valid Message to the dead letter list.
+func (s *consumer) deadLetterUndecodable(level int, raw string, decodeErr error) {
+	s.reportError("decode", "", decodeErr)
+	if err := s.deadLetter("", []byte(raw), 0, "decode: "+decodeErr.Error()); err != nil {
+		s.reportError("dead letter", "", err)
+		return
+	}
+	if s.options.Reliable {
+		s.ack(level, raw)
+	}
+}
@@ -0,0 +1,119 @@
+package redis_mq
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDeadLetterMessage(t *testing.T) {
+	msg := NewMessage("id", []byte("body"))
+	msg.Attempts = 3
+	for _, codec := range []Codec{JSONCodec, BinaryCodec} {
+		payload, _ := codec.Encode(msg)
+		d := &DeadLetter{ID: "id", Payload: payload, Attempts: 3}
+		decoded, err := d.Message()
+		if err != nil || decoded.ID != "id" || string(decoded.Body) != "body" || decoded.Attempts != 3 {
+			t.Fatalf("decoded %+v %v", decoded, err)
+		}
+	}
+}
+
+func TestDeadLetterRequeue(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return 1
+	})
+	defer f.Close()
+
+	msg := NewMessage("id", []byte("body"))
+	msg.Attempts = 3
+	payload, _ := BinaryCodec.Encode(msg)
+	q := NewDeadLetterQueue(client, "topic")
+	raw := encodeDeadLetter("id", payload, 3, "failed")
+	if ok, err := q.requeue(&DeadLetter{ID: "id", Payload: payload, raw: raw}); !ok || err != nil {
+		t.Fatalf("requeue %v %v", ok, err)
+	}
+	// EVAL script numkeys dead list raw payload valueKey
+	eval := f.commands("EVAL")[0]
+	if eval[3] != "topic:dead" || eval[4] != "topic:list" || eval[5] != raw {
+		t.Fatalf("eval %q", eval)
+	}
+	requeued := &Message{}
+	if err := decodeMessage(JSONCodec, []byte(eval[6]), requeued); err != nil || requeued.ID != "id" || requeued.Attempts != 0 {
+		t.Fatalf("requeued %+v %v", requeued, err)
+	}
+
+	// payloads that are not messages are requeued as they are
+	if _, err := q.requeue(&DeadLetter{Payload: []byte("garbage"), raw: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if eval := f.commands("EVAL")[1]; eval[6] != "garbage" {
+		t.Fatalf("eval %q", eval)
+	}
+}
+
+func TestDeadLetterRequeueStream(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return 1
+	})
+	defer f.Close()
+
+	q := NewProducer(client, UseStream(0)).DeadLetterQueue("topic")
+	payload, _ := JSONCodec.Encode(NewMessage("id", []byte("body")))
+	if _, err := q.requeue(&DeadLetter{ID: "id", Payload: payload, raw: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	// EVAL script numkeys dead stream raw payload valueKey
+	if eval := f.commands("EVAL")[0]; eval[4] != "topic:stream" || eval[7] != streamValueKey {
+		t.Fatalf("eval %q", eval)
+	}
+}
+
+func TestDeadLetterRequeuePriority(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return 1
+	})
+	defer f.Close()
+
+	q := NewProducer(client, UseProducerPriorityLevels(3)).DeadLetterQueue("topic")
+	msg := NewMessage("id", []byte("body"))
+	msg.Priority = 5
+	payload, _ := JSONCodec.Encode(msg)
+	if _, err := q.requeue(&DeadLetter{ID: "id", Payload: payload, raw: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := evalArgs(f.commands("EVAL")[0]); keys[1] != "topic:list:2" {
+		t.Fatalf("keys %q", keys)
+	}
+}
+
+// prefixCodec is a custom codec, unknown to decodeMessage.
+type prefixCodec struct{}
+
+func (prefixCodec) Encode(msg *Message) ([]byte, error) {
+	data, err := JSONCodec.Encode(msg)
+	return append([]byte("x"), data...), err
+}
+
+func (prefixCodec) Decode(data []byte, msg *Message) error {
+	return JSONCodec.Decode(data[1:], msg)
+}
+
+func TestDeadLetterRequeueCodec(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return 1
+	})
+	defer f.Close()
+
+	msg := NewMessage("id", []byte("body"))
+	msg.Attempts = 3
+	payload, _ := prefixCodec{}.Encode(msg)
+	q := NewSimpleMQConsumer(context.Background(), client, "topic", UseCodec(prefixCodec{})).DeadLetterQueue()
+	if _, err := q.requeue(&DeadLetter{ID: "id", Payload: payload, raw: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	_, argv := evalArgs(f.commands("EVAL")[0])
+	requeued := &Message{}
+	if err := (prefixCodec{}).Decode([]byte(argv[1]), requeued); err != nil || requeued.ID != "id" || requeued.Attempts != 0 {
+		t.Fatalf("requeued %q %+v %v", argv[1], requeued, err)
+	}
+}
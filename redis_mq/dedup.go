@@ -0,0 +1,89 @@
+package redis_mq
+
+import (
+	"time"
+)
+
+const dedupSuffix = ":dedup"
+
+// UseDedup makes the consumer handle a message id at most once within window.
+// While a message is being handled its id is locked for the visibility timeout (or window
+// if the consumer is not reliable), a failed message releases it so it can be retried.
+func UseDedup(window time.Duration) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.DedupWindow = window
+	}
+}
+
+// UseProducerDedup makes Publish drop messages whose id (see WithID) was already
+// published to the topic within window, so retried publishes are idempotent.
+func UseProducerDedup(window time.Duration) ProducerOption {
+	return func(o *ProducerOptions) {
+		o.DedupWindow = window
+	}
+}
+
+func (p *Producer) dedupKey(topicName, id string) string {
+	return topicName + dedupSuffix + ":pub:" + id
+}
+
+// publishOnce runs publish unless the id was published within the dedup window.
+func (p *Producer) publishOnce(topicName, id string, publish func() error) error {
+	if p.options.DedupWindow <= 0 {
+		return publish()
+	}
+	key := p.dedupKey(topicName, id)
+	ok, err := p.redisCmd.SetNX(key, 1, p.options.DedupWindow).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if err := publish(); err != nil {
+		p.redisCmd.Del(key)
+		return err
+	}
+	return nil
+}
+
+func (s *consumer) dedupKey(msg *Message) string {
+	if s.useStream() {
+		return s.topicName + dedupSuffix + ":" + s.options.StreamGroup + ":" + msg.ID
+	}
+	return s.topicName + dedupSuffix + ":" + msg.ID
+}
+
+// claimDedup locks the message id and reports whether the message should be handled.
+func (s *consumer) claimDedup(msg *Message) bool {
+	if s.options.DedupWindow <= 0 {
+		return true
+	}
+	ttl := s.options.DedupWindow
+	if (s.options.Reliable || s.useStream()) && s.options.VisibilityTimeout < ttl {
+		ttl = s.options.VisibilityTimeout
+	}
+	ok, err := s.redisCmd.SetNX(s.dedupKey(msg), 1, ttl).Result()
+	if err != nil {
+		s.reportError("dedup", msg.ID, err)
+		return true
+	}
+	return ok
+}
+
+// completeDedup keeps the message id for the whole window after it was handled.
+func (s *consumer) completeDedup(msg *Message) {
+	if s.options.DedupWindow <= 0 {
+		return
+	}
+	if err := s.redisCmd.Set(s.dedupKey(msg), 1, s.options.DedupWindow).Err(); err != nil {
+		s.reportError("dedup", msg.ID, err)
+	}
+}
+
+func (s *consumer) releaseDedup(msg *Message) {
+	if s.options.DedupWindow <= 0 {
+		return
+	}
+	s.redisCmd.Del(s.dedupKey(msg))
+}
@@ -0,0 +1,89 @@
+package redis_mq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsumerDedup(t *testing.T) {
+	claimed := true
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		if args[0] == "SET" && !claimed {
+			return nil
+		}
+		if args[0] == "DEL" {
+			return 1
+		}
+		return fakeStatus("OK")
+	})
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic", UseDedup(time.Hour), UseReliable(time.Minute))
+	msg := NewMessage("id", []byte("body"))
+	if !s.claimDedup(msg) {
+		t.Fatal("not claimed")
+	}
+	// locked for the visibility timeout while it is handled
+	if set := strings.Join(f.commands("SET")[0], " "); set != "SET topic:dedup:id 1 ex 60 nx" {
+		t.Fatal(set)
+	}
+	s.completeDedup(msg)
+	if set := strings.Join(f.commands("SET")[1], " "); set != "SET topic:dedup:id 1 ex 3600" {
+		t.Fatal(set)
+	}
+	s.releaseDedup(msg)
+	if del := strings.Join(f.commands("DEL")[0], " "); del != "DEL topic:dedup:id" {
+		t.Fatal(del)
+	}
+	claimed = false
+	if s.claimDedup(msg) {
+		t.Fatal("claimed twice")
+	}
+
+	// every stream group handles the message once
+	s = NewSimpleMQConsumer(context.Background(), client, "topic", UseDedup(time.Second), UseStreamGroup("group", "c"))
+	if key := s.dedupKey(msg); key != "topic:dedup:group:id" {
+		t.Fatal(key)
+	}
+}
+
+func TestProducerDedup(t *testing.T) {
+	claimed := true
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		if args[0] == "SET" && !claimed {
+			return nil
+		}
+		return fakeStatus("OK")
+	})
+	defer f.Close()
+
+	p := NewProducer(client, UseProducerDedup(time.Minute))
+	published := 0
+	publish := func() error {
+		published++
+		return nil
+	}
+	if err := p.publishOnce("topic", "id", publish); err != nil || published != 1 {
+		t.Fatalf("publish %d %v", published, err)
+	}
+	if set := strings.Join(f.commands("SET")[0], " "); set != "SET topic:dedup:pub:id 1 ex 60 nx" {
+		t.Fatal(set)
+	}
+	claimed = false
+	if err := p.publishOnce("topic", "id", publish); err != nil || published != 1 {
+		t.Fatalf("publish %d %v", published, err)
+	}
+
+	// a failed publish can be retried
+	claimed = true
+	failed := errors.New("failed")
+	if err := p.publishOnce("topic", "id2", func() error { return failed }); err != failed {
+		t.Fatal(err)
+	}
+	if del := f.commands("DEL"); len(del) != 1 || del[0][1] != "topic:dedup:pub:id2" {
+		t.Fatalf("del %q", del)
+	}
+}
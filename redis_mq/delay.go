@@ -0,0 +1,211 @@
+package redis_mq
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const promoteBatchSize = 100
+
+var ErrDelayMessageNotFound = errors.New("delay message not found")
+
+// The delay zset holds message ids scored by their due time in unix milliseconds,
+// the encoded messages are kept in the <topic>:zset:msgs hash, so a pending message
+// can be cancelled or rescheduled by its id.
+// Members written by older versions are the encoded message itself, without a hash entry,
+// scored in unix seconds. Scores below legacyScoreLimit are read as seconds, so old members
+// and old producers keep working; consumers must be upgraded before producers.
+const legacyScoreLimit = 100000000000
+
+// scheduleScript stores the message and schedules its id. The priority of the message (ARGV[4])
+// is kept in the <topic>:zset:levels hash, so it is promoted into the list of its priority.
+var scheduleScript = redis.NewScript(`
+redis.call('HSET', KEYS[2], ARGV[2], ARGV[3])
+if tonumber(ARGV[4]) > 0 then
+	redis.call('HSET', KEYS[3], ARGV[2], ARGV[4])
+else
+	redis.call('HDEL', KEYS[3], ARGV[2])
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// promoteScript moves due delayed messages (at most ARGV[2]) to the tail of the list of their
+// priority (KEYS[4] is priority 0, higher priorities are capped at the last key), or into the
+// stream KEYS[4] when ARGV[3] (the stream value key) is set, so every group receives them.
+// ARGV[1] is now in milliseconds, ARGV[5] in seconds for the scores below ARGV[4].
+// It returns the number of moved messages and the due time in milliseconds of the next one
+// (” if there is none).
+var promoteScript = redis.NewScript(`
+redis.replicate_commands()
+local limit = tonumber(ARGV[2])
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[5], 'LIMIT', 0, limit)
+if #ids < limit then
+	local due = redis.call('ZRANGEBYSCORE', KEYS[1], ARGV[4], ARGV[1], 'LIMIT', 0, limit - #ids)
+	for _, id in ipairs(due) do
+		table.insert(ids, id)
+	end
+end
+for i, id in ipairs(ids) do
+	local m = redis.call('HGET', KEYS[2], id)
+	if m then
+		redis.call('HDEL', KEYS[2], id)
+	else
+		m = id
+	end
+	local level = tonumber(redis.call('HGET', KEYS[3], id)) or 0
+	if level > 0 then
+		redis.call('HDEL', KEYS[3], id)
+	end
+	level = math.max(0, math.min(level, #KEYS - 4))
+	redis.call('ZREM', KEYS[1], id)
+	if ARGV[3] ~= '' then
+		redis.call('XADD', KEYS[4], '*', ARGV[3], m)
+	else
+		redis.call('RPUSH', KEYS[4 + level], m)
+	end
+end
+local first = redis.call('ZRANGEBYSCORE', KEYS[1], '(' .. ARGV[5], '+inf', 'WITHSCORES', 'LIMIT', 0, 1)
+local next = first[2] or ''
+if next ~= '' and tonumber(next) < tonumber(ARGV[4]) then
+	next = string.format('%d', tonumber(next) * 1000)
+end
+return {#ids, next}
+`)
+
+var cancelScript = redis.NewScript(`
+local n = redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return n
+`)
+
+var rescheduleScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+func delayKeys(topicName string) []string {
+	return []string{topicName + zsetSuffix, topicName + delayMsgSuffix, topicName + delayLevelSuffix}
+}
+
+func scheduleMessage(cmd redis.Cmdable, codec Codec, topicName string, msg *Message, at time.Time) error {
+	msg.DelayTime = at.Unix()
+	sendData, err := encodeMessage(codec, msg)
+	if err != nil {
+		return err
+	}
+	return scheduleScript.Run(cmd, delayKeys(topicName), unixMilli(at), msg.ID, sendData, msg.Priority).Err()
+}
+
+// promoteDelayMessage moves at most promoteBatchSize due messages from the delay zset to the
+// topic's list (or stream), where they are fetched, acked and retried like any other message.
+// It returns the number of moved messages and how long until the next one is due.
+func (s *consumer) promoteDelayMessage() (int64, time.Duration, error) {
+	keys := delayKeys(s.topicName)
+	t := time.Now()
+	now := unixMilli(t)
+	var cmd *redis.Cmd
+	if s.useStream() {
+		cmd = promoteScript.Run(s.redisCmd, append(keys, s.streamKey()), now, promoteBatchSize, streamValueKey, legacyScoreLimit, t.Unix())
+	} else {
+		for level := 0; level < s.priorityLevels(); level++ {
+			keys = append(keys, s.listKey(level))
+		}
+		cmd = promoteScript.Run(s.redisCmd, keys, now, promoteBatchSize, "", legacyScoreLimit, t.Unix())
+	}
+	v, err := cmd.Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	rev, _ := v.([]interface{})
+	if len(rev) != 2 {
+		return 0, 0, errors.New("unexpected promote script reply")
+	}
+	n, _ := rev[0].(int64)
+	first, _ := rev[1].(string)
+	next := s.options.MaxPollInterval
+	if score, err := strconv.ParseFloat(first, 64); err == nil {
+		next = time.Duration(int64(score)-now) * time.Millisecond
+	}
+	return n, next, nil
+}
+
+func (s *consumer) startPromoteDelayMessage() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.options.Logger.Printf("stop promote delay message.")
+		backoff := s.newPollBackoff()
+		for {
+			n, next, err := s.promoteDelayMessage()
+			if err != nil {
+				s.reportError("promote delay message", "", err)
+				next = backoff.next()
+			} else {
+				backoff.reset()
+			}
+			if n >= promoteBatchSize {
+				if s.ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+			// wake up when the next message is due, checking at least every MaxPollInterval
+			// for messages added with an earlier due time
+			if next > s.options.MaxPollInterval {
+				next = s.options.MaxPollInterval
+			}
+			if next < s.options.MinPollInterval {
+				next = s.options.MinPollInterval
+			}
+			if !s.wait(next) {
+				return
+			}
+		}
+	}()
+}
+
+// PublishAt publishes a message that is delivered at the given time, with millisecond precision.
+// It returns the message id, to be used with CancelDelayMsg and RescheduleDelayMsg.
+func (p *Producer) PublishAt(topicName string, body []byte, at time.Time, opts ...PublishOption) (string, error) {
+	msg := p.newPublishMessage(body, opts)
+	err := p.publishOnce(topicName, msg.ID, func() error {
+		return scheduleMessage(p.redisCmd, p.options.Codec, topicName, msg, at)
+	})
+	p.observePublish(topicName, 1, err)
+	if err := p.reportError("publish delay message", topicName, msg.ID, err); err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+// CancelDelayMsg removes a delayed message that is not due yet.
+func (p *Producer) CancelDelayMsg(topicName string, id string) error {
+	n, err := cancelScript.Run(p.redisCmd, delayKeys(topicName), id).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDelayMessageNotFound
+	}
+	return nil
+}
+
+// RescheduleDelayMsg changes the due time of a delayed message that is not due yet.
+func (p *Producer) RescheduleDelayMsg(topicName string, id string, at time.Time) error {
+	n, err := rescheduleScript.Run(p.redisCmd, delayKeys(topicName), unixMilli(at), id).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDelayMessageNotFound
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package redis_mq
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromoteDelayMessage(t *testing.T) {
+	var reply []interface{}
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return reply
+	})
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic", NewPollInterval(time.Millisecond, time.Second))
+	reply = []interface{}{int64(2), strconv.FormatInt(unixMilli(time.Now().Add(time.Millisecond*500)), 10)}
+	n, next, err := s.promoteDelayMessage()
+	if err != nil || n != 2 || next <= time.Millisecond*400 || next > time.Millisecond*500 {
+		t.Fatalf("promoted %d next %v %v", n, next, err)
+	}
+	keys, argv := evalArgs(f.commands("EVAL")[0])
+	if strings.Join(keys, ",") != "topic:zset,topic:zset:msgs,topic:zset:levels,topic:list" || argv[2] != "" {
+		t.Fatalf("keys %q argv %q", keys, argv)
+	}
+
+	// nothing scheduled, check again after the max poll interval
+	reply = []interface{}{int64(0), ""}
+	if n, next, err = s.promoteDelayMessage(); err != nil || n != 0 || next != time.Second {
+		t.Fatalf("promoted %d next %v %v", n, next, err)
+	}
+
+	s = NewSimpleMQConsumer(context.Background(), client, "topic", UseStreamGroup("group", "consumer"))
+	if _, _, err = s.promoteDelayMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, argv = evalArgs(f.commands("EVAL")[2]); keys[3] != "topic:stream" || argv[2] != streamValueKey {
+		t.Fatalf("keys %q argv %q", keys, argv)
+	}
+
+	// messages are promoted into the list of their priority
+	s = NewSimpleMQConsumer(context.Background(), client, "topic", UsePriorityLevels(3))
+	if _, _, err = s.promoteDelayMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ = evalArgs(f.commands("EVAL")[3]); strings.Join(keys[3:], ",") != "topic:list,topic:list:1,topic:list:2" {
+		t.Fatalf("keys %q", keys)
+	}
+}
+
+func TestPromoteLegacyScores(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return []interface{}{int64(0), ""}
+	})
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic")
+	if _, _, err := s.promoteDelayMessage(); err != nil {
+		t.Fatal(err)
+	}
+	// argv: nowMs count valueKey legacyLimit nowSec
+	_, argv := evalArgs(f.commands("EVAL")[0])
+	nowMs, _ := strconv.ParseInt(argv[0], 10, 64)
+	nowSec, _ := strconv.ParseInt(argv[4], 10, 64)
+	if argv[3] != strconv.Itoa(legacyScoreLimit) || nowSec != nowMs/1000 {
+		t.Fatalf("argv %q", argv)
+	}
+	// seconds and milliseconds scores of the same time are on either side of the limit
+	if nowSec >= legacyScoreLimit || nowMs < legacyScoreLimit {
+		t.Fatalf("legacy score limit %d between %d and %d", legacyScoreLimit, nowSec, nowMs)
+	}
+}
+
+func TestScheduleMessage(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return 1
+	})
+	defer f.Close()
+
+	at := time.Now().Add(time.Minute)
+	msg := NewMessage("id", []byte("body"))
+	msg.Priority = 2
+	if err := scheduleMessage(client, JSONCodec, "topic", msg, at); err != nil {
+		t.Fatal(err)
+	}
+	// argv: score id msg priority
+	keys, argv := evalArgs(f.commands("EVAL")[0])
+	if strings.Join(keys, ",") != "topic:zset,topic:zset:msgs,topic:zset:levels" ||
+		argv[0] != strconv.FormatInt(unixMilli(at), 10) || argv[1] != "id" || argv[3] != "2" {
+		t.Fatalf("keys %q argv %q", keys, argv)
+	}
+	scheduled := &Message{}
+	if err := decodeMessage(JSONCodec, []byte(argv[2]), scheduled); err != nil || scheduled.DelayTime != at.Unix() {
+		t.Fatalf("scheduled %+v %v", scheduled, err)
+	}
+}
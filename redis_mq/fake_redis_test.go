@@ -0,0 +1,149 @@
+package redis_mq
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+// fakeRedis is a minimal RESP server for tests. Every command is recorded and answered by
+// the reply function: nil is a nil reply, and string, int, int64, []interface{} and error
+// are encoded as bulk string, integer, array and error replies. EVALSHA is always answered
+// with NOSCRIPT, so scripts reach the reply function as EVAL.
+type fakeRedis struct {
+	ln     net.Listener
+	client *redis.Client
+	mu     sync.Mutex
+	cmds   [][]string
+	reply  func(args []string) interface{}
+}
+
+type fakeStatus string
+
+func newFakeRedis(t *testing.T, reply func(args []string) interface{}) (*fakeRedis, *redis.Client) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, reply: reply}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	f.client = redis.NewClient(&redis.Options{Addr: ln.Addr().String()})
+	return f, f.client
+}
+
+func (f *fakeRedis) Close() {
+	f.client.Close()
+	f.ln.Close()
+}
+
+// commands returns the recorded commands named name, upper case.
+func (f *fakeRedis) commands(name string) [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rev [][]string
+	for _, cmd := range f.cmds {
+		if cmd[0] == name {
+			rev = append(rev, cmd)
+		}
+	}
+	return rev
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		args[0] = strings.ToUpper(args[0])
+		var rev interface{}
+		if args[0] == "EVALSHA" {
+			rev = fmt.Errorf("NOSCRIPT No matching script")
+		} else {
+			f.mu.Lock()
+			f.cmds = append(f.cmds, args)
+			f.mu.Unlock()
+			if f.reply != nil {
+				rev = f.reply(args)
+			}
+		}
+		if _, err := io.WriteString(conn, encodeReply(rev)); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		l, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, l+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:l])
+	}
+	return args, nil
+}
+
+func encodeReply(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "$-1\r\n"
+	case fakeStatus:
+		return "+" + string(v) + "\r\n"
+	case error:
+		return "-" + v.Error() + "\r\n"
+	case int:
+		return ":" + strconv.Itoa(v) + "\r\n"
+	case int64:
+		return ":" + strconv.FormatInt(v, 10) + "\r\n"
+	case string:
+		return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	case []interface{}:
+		b := &strings.Builder{}
+		b.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			b.WriteString(encodeReply(e))
+		}
+		return b.String()
+	}
+	panic(fmt.Sprintf("fake redis: unsupported reply %T", v))
+}
+
+// evalArgs splits the arguments of a recorded EVAL into its keys and argv.
+func evalArgs(cmd []string) ([]string, []string) {
+	n, _ := strconv.Atoi(cmd[2])
+	return cmd[3 : 3+n], cmd[3+n:]
+}
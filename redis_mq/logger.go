@@ -0,0 +1,110 @@
+package redis_mq
+
+import (
+	"fmt"
+	"log"
+)
+
+// Logger is implemented by *log.Logger and most structured loggers' printf adapters.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// Error is passed to the OnError callbacks, it describes which operation failed.
+type Error struct {
+	Op        string
+	Topic     string
+	MessageID string
+	Err       error
+}
+
+func (e *Error) Error() string {
+	if e.MessageID != "" {
+		return fmt.Sprintf("redis_mq: %s topic %s message %s: %v", e.Op, e.Topic, e.MessageID, e.Err)
+	}
+	return fmt.Sprintf("redis_mq: %s topic %s: %v", e.Op, e.Topic, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// UseLogger sets the logger of the consumer, the standard log package by default.
+func UseLogger(l Logger) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Logger = l
+	}
+}
+
+// OnError sets a callback for the errors of the consumer's background goroutines,
+// called with an *Error in addition to logging it.
+func OnError(fn func(err error)) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.OnError = fn
+	}
+}
+
+// UseProducerLogger sets the logger of the producer, the standard log package by default.
+func UseProducerLogger(l Logger) ProducerOption {
+	return func(o *ProducerOptions) {
+		o.Logger = l
+	}
+}
+
+// OnProducerError sets a callback for failed publishes, called with an *Error.
+// The error is returned to the caller of the publish method as well.
+func OnProducerError(fn func(err error)) ProducerOption {
+	return func(o *ProducerOptions) {
+		o.OnError = fn
+	}
+}
+
+// UseSchedulerLogger sets the logger of the scheduler, the standard log package by default.
+func UseSchedulerLogger(l Logger) SchedulerOption {
+	return func(o *SchedulerOptions) {
+		o.Logger = l
+	}
+}
+
+// OnSchedulerError sets a callback for the errors of the scheduler's background goroutine,
+// called with an *Error whose Topic is the scheduler name.
+func OnSchedulerError(fn func(err error)) SchedulerOption {
+	return func(o *SchedulerOptions) {
+		o.OnError = fn
+	}
+}
+
+func (s *consumer) reportError(op, messageID string, err error) {
+	e := &Error{Op: op, Topic: s.topicName, MessageID: messageID, Err: err}
+	s.options.Logger.Printf("%v \n", e)
+	if s.options.OnError != nil {
+		s.options.OnError(e)
+	}
+}
+
+// reportError reports err of a publish to the OnProducerError callback and returns it unchanged.
+func (p *Producer) reportError(op, topicName, messageID string, err error) error {
+	if err == nil {
+		return nil
+	}
+	e := &Error{Op: op, Topic: topicName, MessageID: messageID, Err: err}
+	p.options.Logger.Printf("%v \n", e)
+	if p.options.OnError != nil {
+		p.options.OnError(e)
+	}
+	return err
+}
+
+func (s *Scheduler) reportError(op string, err error) {
+	e := &Error{Op: op, Topic: s.name, Err: err}
+	s.options.Logger.Printf("%v \n", e)
+	if s.options.OnError != nil {
+		s.options.OnError(e)
+	}
+}
@@ -0,0 +1,100 @@
+package redis_mq
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Metrics receives the events of producers and consumers.
+// redis_mq/prom has an implementation that exports them in the prometheus format.
+type Metrics interface {
+	Published(topic string, n int)
+	PublishFailed(topic string, n int)
+	Fetched(topic string, n int)
+	// Handled is called after the handler returned, with the number of messages it received.
+	Handled(topic string, n int, duration time.Duration, err error)
+	Retried(topic string)
+	DeadLettered(topic string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Published(topic string, n int)                                  {}
+func (nopMetrics) PublishFailed(topic string, n int)                              {}
+func (nopMetrics) Fetched(topic string, n int)                                    {}
+func (nopMetrics) Handled(topic string, n int, duration time.Duration, err error) {}
+func (nopMetrics) Retried(topic string)                                           {}
+func (nopMetrics) DeadLettered(topic string)                                      {}
+
+func UseMetrics(m Metrics) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Metrics = m
+	}
+}
+
+func UseProducerMetrics(m Metrics) ProducerOption {
+	return func(o *ProducerOptions) {
+		o.Metrics = m
+	}
+}
+
+func (p *Producer) observePublish(topicName string, n int, err error) {
+	if err != nil {
+		p.options.Metrics.PublishFailed(topicName, n)
+		return
+	}
+	p.options.Metrics.Published(topicName, n)
+}
+
+// Stats is a snapshot of the redis keys of a topic.
+type Stats struct {
+	// ListLength is the number of ready messages, of all priority levels.
+	ListLength int64
+	// DelayLength is the number of delayed and retried messages, including due ones that were
+	// not moved to the list yet.
+	DelayLength  int64
+	UnackLength  int64
+	DeadLength   int64
+	StreamLength int64
+	// OldestMessageAge is the age of the message at the head of the (priority 0) list.
+	OldestMessageAge time.Duration
+}
+
+// Stats returns the queue sizes of the topic, using the priority levels of the producer.
+func (p *Producer) Stats(topicName string) (*Stats, error) {
+	levels := p.priorityLevels()
+	var listLens, unackLens []*redis.IntCmd
+	var delayLen, deadLen, streamLen *redis.IntCmd
+	var head *redis.StringCmd
+	_, err := p.redisCmd.Pipelined(func(pip redis.Pipeliner) error {
+		for level := 0; level < levels; level++ {
+			listLens = append(listLens, pip.LLen(priorityKey(topicName+listSuffix, level)))
+			unackLens = append(unackLens, pip.ZCard(priorityKey(topicName+unackSuffix, level)))
+		}
+		delayLen = pip.ZCard(topicName + zsetSuffix)
+		deadLen = pip.LLen(topicName + deadSuffix)
+		streamLen = pip.XLen(topicName + streamSuffix)
+		head = pip.LIndex(topicName+listSuffix, 0)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	stats := &Stats{
+		DelayLength:  delayLen.Val(),
+		DeadLength:   deadLen.Val(),
+		StreamLength: streamLen.Val(),
+	}
+	for i := range listLens {
+		stats.ListLength += listLens[i].Val()
+		stats.UnackLength += unackLens[i].Val()
+	}
+	if raw := head.Val(); raw != "" {
+		msg := &Message{}
+		if decodeMessage(p.options.Codec, []byte(raw), msg) == nil && msg.Timestamp > 0 {
+			stats.OldestMessageAge = time.Since(time.Unix(msg.Timestamp, 0))
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,159 @@
+package redis_mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// ErrHandlerTimeout is returned by the Timeout middleware.
+var ErrHandlerTimeout = errors.New("handler timeout")
+
+// HandlerFunc adapts a function to an ErrorHandler.
+type HandlerFunc func(msg *Message) error
+
+func (f HandlerFunc) HandleMessage(msg *Message) error {
+	return f(msg)
+}
+
+// Middleware wraps the handler of a consumer. It works on ErrorHandlers, a Handler set with
+// SetHandler is wrapped as a handler returning nil; errors returned by the middleware chain
+// around it are reported to the logger and OnError, and the message is not acked.
+type Middleware func(next ErrorHandler) ErrorHandler
+
+// UseMiddleware installs a middleware chain, the first middleware is the outermost.
+// Batch handlers are not wrapped.
+func UseMiddleware(middlewares ...Middleware) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Middlewares = append(o.Middlewares, middlewares...)
+	}
+}
+
+// handlerChain is a snapshot of the handler of the consumer, wrapped in the middlewares, taken
+// whenever a handler is set. acks reports whether its error drives ack and retry (SetErrorHandler)
+// or is only reported (SetHandler). A batch handler is not wrapped.
+type handlerChain struct {
+	handler   ErrorHandler
+	acks      bool
+	batch     BatchHandler
+	batchSize int
+}
+
+// handlers returns the current handler chain.
+func (s *consumer) handlers() handlerChain {
+	chain, _ := s.chain.Load().(handlerChain)
+	return chain
+}
+
+// fetchSize is how many messages are fetched at once.
+func (c handlerChain) fetchSize() int {
+	if c.batch == nil || c.batchSize < 1 {
+		return 1
+	}
+	return c.batchSize
+}
+
+func (s *consumer) buildHandler() handlerChain {
+	if s.batchHandler != nil {
+		return handlerChain{batch: s.batchHandler, batchSize: s.batchSize}
+	}
+	var h ErrorHandler
+	switch {
+	case s.errHandler != nil:
+		h = s.errHandler
+	case s.handler != nil:
+		handler := s.handler
+		h = HandlerFunc(func(msg *Message) error {
+			handler.HandleMessage(msg)
+			return nil
+		})
+	default:
+		return handlerChain{}
+	}
+	for i := len(s.options.Middlewares) - 1; i >= 0; i-- {
+		h = s.options.Middlewares[i](h)
+	}
+	return handlerChain{handler: h, acks: s.errHandler != nil}
+}
+
+// PanicError is returned by the Recover middleware when the handler panicked.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panic: %v", e.Value)
+}
+
+// Recover turns a panicking handler into a *PanicError, so the consumer keeps running.
+// Handlers set with SetErrorHandler or SetContextHandler retry the message like for any
+// other error. With SetHandler the error is only reported: the message is not acked, so a
+// reliable consumer delivers it again after the visibility timeout, other consumers drop it.
+func Recover() Middleware {
+	return func(next ErrorHandler) ErrorHandler {
+		return HandlerFunc(func(msg *Message) (err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					err = &PanicError{Value: v, Stack: debug.Stack()}
+				}
+			}()
+			return next.HandleMessage(msg)
+		})
+	}
+}
+
+// Timeout returns ErrHandlerTimeout when the handler does not return within d, and cancels
+// Message.Context. The handler can not be stopped, it keeps running in the background
+// and its result is ignored. It handles a copy of the message, so the message can be
+// retried while the handler is still running.
+func Timeout(d time.Duration) Middleware {
+	return func(next ErrorHandler) ErrorHandler {
+		return HandlerFunc(func(msg *Message) error {
+			ctx, cancel := context.WithTimeout(msg.Context(), d)
+			defer cancel()
+			cp := msg.clone()
+			cp.ctx = ctx
+			done := make(chan error, 1)
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if v := recover(); v != nil {
+						panicked <- v
+					}
+				}()
+				done <- next.HandleMessage(cp)
+			}()
+			select {
+			case err := <-done:
+				return err
+			case v := <-panicked:
+				// panic again on the consumer goroutine, where Recover can catch it
+				panic(v)
+			case <-ctx.Done():
+				return ErrHandlerTimeout
+			}
+		})
+	}
+}
+
+// Logging logs every handled message as key=value pairs, with its duration and error.
+func Logging(l Logger) Middleware {
+	if l == nil {
+		l = stdLogger{}
+	}
+	return func(next ErrorHandler) ErrorHandler {
+		return HandlerFunc(func(msg *Message) error {
+			start := time.Now()
+			err := next.HandleMessage(msg)
+			if err != nil {
+				l.Printf("redis_mq: handled topic=%s id=%s attempts=%d duration=%s error=%q \n", msg.topic, msg.ID, msg.Attempts, time.Since(start), err.Error())
+			} else {
+				l.Printf("redis_mq: handled topic=%s id=%s attempts=%d duration=%s \n", msg.topic, msg.ID, msg.Attempts, time.Since(start))
+			}
+			return err
+		})
+	}
+}
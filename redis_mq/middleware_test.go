@@ -0,0 +1,115 @@
+package redis_mq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) Middleware {
+		return func(next ErrorHandler) ErrorHandler {
+			return HandlerFunc(func(msg *Message) error {
+				calls = append(calls, name)
+				return next.HandleMessage(msg)
+			})
+		}
+	}
+	s := NewSimpleMQConsumer(context.Background(), nil, "topic", UseMiddleware(mark("a"), mark("b")))
+	s.setHandler(nil, HandlerFunc(func(msg *Message) error {
+		calls = append(calls, "handler")
+		return nil
+	}))
+	if err := s.chain.Load().(handlerChain).handler.HandleMessage(&Message{}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(calls, ",") != "a,b,handler" {
+		t.Fatalf("calls %v", calls)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := Recover()(HandlerFunc(func(msg *Message) error {
+		panic("boom")
+	}))
+	err := h.HandleMessage(&Message{})
+	if pe, ok := err.(*PanicError); !ok || pe.Value != "boom" {
+		t.Fatalf("err %#v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	h := Timeout(time.Millisecond * 10)(HandlerFunc(func(msg *Message) error {
+		<-msg.Context().Done()
+		return nil
+	}))
+	if err := h.HandleMessage(&Message{}); err != ErrHandlerTimeout {
+		t.Fatalf("err %v", err)
+	}
+
+	failed := errors.New("failed")
+	h = Timeout(time.Second)(HandlerFunc(func(msg *Message) error {
+		return failed
+	}))
+	if err := h.HandleMessage(&Message{}); err != failed {
+		t.Fatalf("err %v", err)
+	}
+
+	h = Recover()(Timeout(time.Second)(HandlerFunc(func(msg *Message) error {
+		panic("boom")
+	})))
+	if _, ok := h.HandleMessage(&Message{}).(*PanicError); !ok {
+		t.Fatal("panic in timeout handler not recovered")
+	}
+}
+
+func TestTimeoutCopiesMessage(t *testing.T) {
+	release := make(chan struct{})
+	done := make(chan struct{})
+	h := Timeout(time.Millisecond * 10)(HandlerFunc(func(msg *Message) error {
+		<-release
+		msg.Headers["k"] = "handler"
+		msg.Attempts = 10
+		close(done)
+		return nil
+	}))
+	msg := NewMessage("id", []byte("body"))
+	msg.Headers = map[string]string{"k": "v"}
+	if err := h.HandleMessage(msg); err != ErrHandlerTimeout {
+		t.Fatalf("err %v", err)
+	}
+	// the consumer retries the message while the handler is still running
+	msg.Attempts++
+	msg.Headers["k"] = "retry"
+	close(release)
+	<-done
+	if msg.Attempts != 1 || msg.Headers["k"] != "retry" {
+		t.Fatalf("msg %+v", msg)
+	}
+}
+
+func TestSetHandlerReplaces(t *testing.T) {
+	s := NewSimpleMQConsumer(context.Background(), nil, "topic")
+	var calls []string
+	s.setHandler(nil, HandlerFunc(func(msg *Message) error {
+		calls = append(calls, "first")
+		return nil
+	}))
+	s.dispatch(&Message{})
+	s.setHandler(handlerFunc(func(msg *Message) {
+		calls = append(calls, "second")
+	}), nil)
+	s.dispatch(&Message{})
+	if strings.Join(calls, ",") != "first,second" {
+		t.Fatalf("calls %v", calls)
+	}
+}
+
+type handlerFunc func(msg *Message)
+
+func (f handlerFunc) HandleMessage(msg *Message) {
+	f(msg)
+}
@@ -0,0 +1,59 @@
+package redis_mq
+
+import (
+	"context"
+	"time"
+)
+
+// NewPollInterval sets how long a consumer waits before polling an empty topic again.
+// The wait starts at min and doubles on every empty poll up to max, and is reset as soon
+// as a message is fetched, so a busy topic is drained without waiting.
+func NewPollInterval(min, max time.Duration) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.MinPollInterval = min
+		o.MaxPollInterval = max
+	}
+}
+
+// pollBackoff is the adaptive wait between empty polls.
+type pollBackoff struct {
+	min, max, cur time.Duration
+}
+
+func (b *pollBackoff) reset() {
+	b.cur = 0
+}
+
+func (b *pollBackoff) next() time.Duration {
+	if b.cur == 0 {
+		b.cur = b.min
+	} else if b.cur *= 2; b.cur > b.max {
+		b.cur = b.max
+	}
+	return b.cur
+}
+
+func (s *consumer) newPollBackoff() *pollBackoff {
+	return &pollBackoff{min: s.options.MinPollInterval, max: s.options.MaxPollInterval}
+}
+
+// wait sleeps for d and reports false if the consumer was stopped in the meantime.
+func (s *consumer) wait(d time.Duration) bool {
+	return sleepContext(s.ctx, d)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// blockingFetch reports whether fetch blocks on redis (BLPOP) while the topic is empty.
+func (s *consumer) blockingFetch() bool {
+	return s.options.UseBLPop && !s.options.Reliable && s.handlers().batch == nil
+}
@@ -0,0 +1,20 @@
+package redis_mq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollBackoff(t *testing.T) {
+	b := &pollBackoff{min: time.Millisecond, max: time.Millisecond * 5}
+	expected := []time.Duration{time.Millisecond, time.Millisecond * 2, time.Millisecond * 4, time.Millisecond * 5, time.Millisecond * 5}
+	for i, d := range expected {
+		if got := b.next(); got != d {
+			t.Errorf("poll %d: got %v want %v", i, got, d)
+		}
+	}
+	b.reset()
+	if got := b.next(); got != time.Millisecond {
+		t.Errorf("after reset: got %v", got)
+	}
+}
@@ -0,0 +1,131 @@
+package redis_mq
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// UsePriorityLevels makes the consumer read n priority lists of the topic, always draining
+// higher priorities first. Priority 0 is the <topic>:list, priority p the <topic>:list:p list.
+// Producers need the same number of levels (UseProducerPriorityLevels).
+// Delayed and retried messages keep their priority, priorities are not supported with UseStreamGroup.
+func UsePriorityLevels(n int) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.PriorityLevels = n
+	}
+}
+
+// UseProducerPriorityLevels makes Publish put messages in the list of their priority,
+// priorities above n-1 are published with priority n-1.
+func UseProducerPriorityLevels(n int) ProducerOption {
+	return func(o *ProducerOptions) {
+		o.PriorityLevels = n
+	}
+}
+
+func priorityLevel(priority, levels int) int {
+	if priority < 0 {
+		return 0
+	}
+	if priority >= levels {
+		return levels - 1
+	}
+	return priority
+}
+
+func priorityKey(key string, level int) string {
+	if level == 0 {
+		return key
+	}
+	return key + ":" + strconv.Itoa(level)
+}
+
+// lpopScript pops the head of the first non-empty list and returns its index with the message.
+var lpopScript = redis.NewScript(`
+for i = 1, #KEYS do
+	local v = redis.call('LPOP', KEYS[i])
+	if v then
+		return {i - 1, v}
+	end
+end
+return false
+`)
+
+func (s *consumer) priorityLevels() int {
+	if s.options.PriorityLevels < 1 || s.useStream() {
+		return 1
+	}
+	return s.options.PriorityLevels
+}
+
+func (s *consumer) listKey(level int) string {
+	return priorityKey(s.topicName+listSuffix, level)
+}
+
+func (s *consumer) unackKey(level int) string {
+	return priorityKey(s.topicName+unackSuffix, level)
+}
+
+// fetch pops the next message, or the next batch of at most limit messages for a batch handler.
+func (s *consumer) fetch(limit int) ([]fetchedMessage, error) {
+	if s.handlers().batch != nil {
+		return s.fetchBatch(limit)
+	}
+	raw, level, err := s.fetchOne()
+	if err != nil {
+		return nil, err
+	}
+	return []fetchedMessage{{raw: raw, level: level}}, nil
+}
+
+// fetchOne pops a message from the highest non-empty priority list and returns its priority level.
+func (s *consumer) fetchOne() (string, int, error) {
+	levels := s.priorityLevels()
+	if s.options.Reliable {
+		return s.fetchReliable()
+	}
+	keys := make([]string, 0, levels)
+	for level := levels - 1; level >= 0; level-- {
+		keys = append(keys, s.listKey(level))
+	}
+	if s.options.UseBLPop {
+		revs, err := s.redisCmd.BLPop(time.Second, keys...).Result()
+		if err != nil {
+			return "", 0, err
+		}
+		for i, key := range keys {
+			if key == revs[0] {
+				return revs[1], levels - 1 - i, nil
+			}
+		}
+		return revs[1], 0, nil
+	}
+	if levels == 1 {
+		raw, err := s.redisCmd.LPop(keys[0]).Result()
+		return raw, 0, err
+	}
+	return popResult(lpopScript.Run(s.redisCmd, keys), levels)
+}
+
+// popResult converts the {index, message} reply of a pop script to the message and its level.
+func popResult(cmd *redis.Cmd, levels int) (string, int, error) {
+	raw, index, err := popIndex(cmd)
+	return raw, levels - 1 - index, err
+}
+
+// popIndex returns the message and the index of the key (or key pair) it was popped from.
+func popIndex(cmd *redis.Cmd) (string, int, error) {
+	v, err := cmd.Result()
+	if err != nil {
+		return "", 0, err
+	}
+	rev, ok := v.([]interface{})
+	if !ok || len(rev) != 2 {
+		return "", 0, redis.Nil
+	}
+	index, _ := rev[0].(int64)
+	raw, _ := rev[1].(string)
+	return raw, int(index), nil
+}
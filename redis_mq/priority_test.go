@@ -0,0 +1,52 @@
+package redis_mq
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func TestPriorityLevel(t *testing.T) {
+	for _, c := range []struct{ priority, levels, level int }{
+		{-1, 3, 0}, {0, 3, 0}, {2, 3, 2}, {5, 3, 2}, {4, 1, 0},
+	} {
+		if level := priorityLevel(c.priority, c.levels); level != c.level {
+			t.Fatalf("priorityLevel(%d, %d) = %d", c.priority, c.levels, level)
+		}
+	}
+	if key := priorityKey("topic:list", 0); key != "topic:list" {
+		t.Fatal(key)
+	}
+	if key := priorityKey("topic:list", 2); key != "topic:list:2" {
+		t.Fatal(key)
+	}
+}
+
+func TestFetchPriority(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		// popped from the second key, topic:list:1
+		return []interface{}{int64(1), "msg"}
+	})
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic", UsePriorityLevels(3))
+	raw, level, err := s.fetchOne()
+	if err != nil || raw != "msg" || level != 1 {
+		t.Fatalf("fetch %q %d %v", raw, level, err)
+	}
+	// highest priority first
+	if keys, _ := evalArgs(f.commands("EVAL")[0]); strings.Join(keys, ",") != "topic:list:2,topic:list:1,topic:list" {
+		t.Fatalf("keys %q", keys)
+	}
+}
+
+func TestPopResultEmpty(t *testing.T) {
+	f, client := newFakeRedis(t, nil)
+	defer f.Close()
+
+	if _, _, err := popResult(lpopScript.Run(client, []string{"a", "b"}), 2); err != redis.Nil {
+		t.Fatalf("err %v", err)
+	}
+}
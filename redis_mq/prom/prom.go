@@ -0,0 +1,198 @@
+// Package prom exports redis_mq metrics in the prometheus text format,
+// without depending on the prometheus client library.
+package prom
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lpxxn/go-utils/redis_mq"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the handler duration histogram.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Metrics implements redis_mq.Metrics and http.Handler.
+type Metrics struct {
+	namespace string
+	buckets   []float64
+	mu        sync.Mutex
+	counters  map[string]map[string]float64
+	durations map[string]*histogram
+	producer  *redis_mq.Producer
+	topics    []string
+	_         struct{}
+}
+
+var _ redis_mq.Metrics = (*Metrics)(nil)
+
+// New returns metrics named <namespace>_<metric>, e.g. redis_mq_published_total.
+func New(namespace string) *Metrics {
+	return &Metrics{
+		namespace: namespace,
+		buckets:   DefaultBuckets,
+		counters:  map[string]map[string]float64{},
+		durations: map[string]*histogram{},
+	}
+}
+
+// WatchStats exports the queue sizes of the topics (producer.Stats) on every scrape.
+func (m *Metrics) WatchStats(producer *redis_mq.Producer, topics ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.producer = producer
+	m.topics = topics
+}
+
+func (m *Metrics) add(name, topic string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.counters[name]
+	if !ok {
+		c = map[string]float64{}
+		m.counters[name] = c
+	}
+	c[topic] += v
+}
+
+func (m *Metrics) Published(topic string, n int) { m.add("published_total", topic, float64(n)) }
+func (m *Metrics) PublishFailed(topic string, n int) {
+	m.add("publish_failed_total", topic, float64(n))
+}
+func (m *Metrics) Fetched(topic string, n int) { m.add("fetched_total", topic, float64(n)) }
+func (m *Metrics) Retried(topic string)        { m.add("retried_total", topic, 1) }
+func (m *Metrics) DeadLettered(topic string)   { m.add("dead_lettered_total", topic, 1) }
+
+func (m *Metrics) Handled(topic string, n int, duration time.Duration, err error) {
+	if err != nil {
+		m.add("handle_failed_total", topic, float64(n))
+	} else {
+		m.add("handled_total", topic, float64(n))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.durations[topic]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.durations[topic] = h
+	}
+	seconds := duration.Seconds()
+	for i, b := range m.buckets {
+		if seconds <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTo writes all metrics in the prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	b := &strings.Builder{}
+	m.mu.Lock()
+	names := make([]string, 0, len(m.counters))
+	for name := range m.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		full := m.name(name)
+		fmt.Fprintf(b, "# TYPE %s counter\n", full)
+		c := m.counters[name]
+		for _, topic := range sortedKeys(c) {
+			fmt.Fprintf(b, "%s{topic=\"%s\"} %v\n", full, escape(topic), c[topic])
+		}
+	}
+	if len(m.durations) > 0 {
+		full := m.name("handle_duration_seconds")
+		fmt.Fprintf(b, "# TYPE %s histogram\n", full)
+		topics := make([]string, 0, len(m.durations))
+		for topic := range m.durations {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		for _, topic := range topics {
+			h, t := m.durations[topic], escape(topic)
+			for i, le := range m.buckets {
+				fmt.Fprintf(b, "%s_bucket{topic=\"%s\",le=\"%v\"} %d\n", full, t, le, h.counts[i])
+			}
+			fmt.Fprintf(b, "%s_bucket{topic=\"%s\",le=\"+Inf\"} %d\n", full, t, h.count)
+			fmt.Fprintf(b, "%s_sum{topic=\"%s\"} %v\n", full, t, h.sum)
+			fmt.Fprintf(b, "%s_count{topic=\"%s\"} %d\n", full, t, h.count)
+		}
+	}
+	producer, topics := m.producer, m.topics
+	m.mu.Unlock()
+	if producer != nil {
+		m.writeStats(b, producer, topics)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) writeStats(b *strings.Builder, producer *redis_mq.Producer, topics []string) {
+	gauges := []struct {
+		name  string
+		value func(s *redis_mq.Stats) float64
+	}{
+		{"list_length", func(s *redis_mq.Stats) float64 { return float64(s.ListLength) }},
+		{"delay_length", func(s *redis_mq.Stats) float64 { return float64(s.DelayLength) }},
+		{"unack_length", func(s *redis_mq.Stats) float64 { return float64(s.UnackLength) }},
+		{"dead_length", func(s *redis_mq.Stats) float64 { return float64(s.DeadLength) }},
+		{"stream_length", func(s *redis_mq.Stats) float64 { return float64(s.StreamLength) }},
+		{"oldest_message_age_seconds", func(s *redis_mq.Stats) float64 { return s.OldestMessageAge.Seconds() }},
+	}
+	stats := make(map[string]*redis_mq.Stats, len(topics))
+	for _, topic := range topics {
+		if s, err := producer.Stats(topic); err == nil {
+			stats[topic] = s
+		}
+	}
+	for _, g := range gauges {
+		full := m.name(g.name)
+		fmt.Fprintf(b, "# TYPE %s gauge\n", full)
+		for _, topic := range topics {
+			if s, ok := stats[topic]; ok {
+				fmt.Fprintf(b, "%s{topic=\"%s\"} %v\n", full, escape(topic), g.value(s))
+			}
+		}
+	}
+}
+
+func (m *Metrics) name(name string) string {
+	if m.namespace == "" {
+		return name
+	}
+	return m.namespace + "_" + name
+}
+
+func sortedKeys(c map[string]float64) []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(v string) string {
+	return labelEscaper.Replace(v)
+}
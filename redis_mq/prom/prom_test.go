@@ -0,0 +1,37 @@
+package prom
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTo(t *testing.T) {
+	m := New("redis_mq")
+	m.Published("orders", 3)
+	m.Handled("orders", 1, time.Millisecond*20, nil)
+	m.Handled("orders", 1, time.Second*20, errors.New("failed"))
+	m.DeadLettered(`a"b`)
+
+	b := &strings.Builder{}
+	if _, err := m.WriteTo(b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE redis_mq_published_total counter",
+		`redis_mq_published_total{topic="orders"} 3`,
+		`redis_mq_handled_total{topic="orders"} 1`,
+		`redis_mq_handle_failed_total{topic="orders"} 1`,
+		`redis_mq_dead_lettered_total{topic="a\"b"} 1`,
+		`redis_mq_handle_duration_seconds_bucket{topic="orders",le="0.01"} 0`,
+		`redis_mq_handle_duration_seconds_bucket{topic="orders",le="0.025"} 1`,
+		`redis_mq_handle_duration_seconds_bucket{topic="orders",le="+Inf"} 2`,
+		`redis_mq_handle_duration_seconds_count{topic="orders"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package redis_mq
+
+import "context"
+
+// TextMapCarrier gives a Propagator access to the message headers.
+// It has the method set of the OpenTelemetry carrier, so it can be passed to an
+// OpenTelemetry propagator as is.
+type TextMapCarrier interface {
+	Get(key string) string
+	Set(key, value string)
+	Keys() []string
+}
+
+// Propagator injects the trace context of a context into message headers and extracts it
+// on the consumer side, so traces continue across the queue. An OpenTelemetry
+// TextMapPropagator can be used with a wrapper forwarding both methods.
+type Propagator interface {
+	Inject(ctx context.Context, carrier TextMapCarrier)
+	Extract(ctx context.Context, carrier TextMapCarrier) context.Context
+}
+
+// HeaderCarrier is the TextMapCarrier of Message.Headers.
+type HeaderCarrier map[string]string
+
+func (c HeaderCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c HeaderCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// UsePropagator extracts the trace context from the headers of every message before it
+// is handled, the handler gets it from Message.Context.
+func UsePropagator(p Propagator) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Propagator = p
+	}
+}
+
+// UseProducerPropagator injects the trace context of the context set by WithContext
+// into the headers of published messages.
+func UseProducerPropagator(p Propagator) ProducerOption {
+	return func(o *ProducerOptions) {
+		o.Propagator = p
+	}
+}
+
+func (p *Producer) inject(msg *Message) {
+	if p.options.Propagator == nil || msg.ctx == nil {
+		return
+	}
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	p.options.Propagator.Inject(msg.ctx, HeaderCarrier(msg.Headers))
+	msg.ctx = nil
+}
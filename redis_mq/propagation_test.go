@@ -0,0 +1,39 @@
+package redis_mq
+
+import (
+	"context"
+	"testing"
+)
+
+type traceKey struct{}
+
+type testPropagator struct{}
+
+func (testPropagator) Inject(ctx context.Context, carrier TextMapCarrier) {
+	if id, ok := ctx.Value(traceKey{}).(string); ok {
+		carrier.Set("traceparent", id)
+	}
+}
+
+func (testPropagator) Extract(ctx context.Context, carrier TextMapCarrier) context.Context {
+	if id := carrier.Get("traceparent"); id != "" {
+		return context.WithValue(ctx, traceKey{}, id)
+	}
+	return ctx
+}
+
+func TestPropagation(t *testing.T) {
+	p := NewProducer(nil, UseProducerPropagator(testPropagator{}))
+	ctx := context.WithValue(context.Background(), traceKey{}, "00-trace-span-01")
+	msg := p.newPublishMessage([]byte("body"), []PublishOption{WithContext(ctx)})
+	if msg.Headers["traceparent"] != "00-trace-span-01" {
+		t.Fatalf("headers %v", msg.Headers)
+	}
+
+	s := NewSimpleMQConsumer(context.Background(), nil, "topic", UsePropagator(testPropagator{}))
+	received := &Message{Headers: msg.Headers}
+	s.withContext(received)
+	if id, _ := received.Context().Value(traceKey{}).(string); id != "00-trace-span-01" {
+		t.Fatalf("extracted %q", id)
+	}
+}
@@ -0,0 +1,76 @@
+package redis_mq
+
+import (
+	"context"
+	"time"
+)
+
+// PublishOption sets optional fields of a published message.
+type PublishOption func(msg *Message)
+
+// WithHeader sets a header of the message, e.g. a trace id or the content type.
+func WithHeader(key, value string) PublishOption {
+	return func(msg *Message) {
+		if msg.Headers == nil {
+			msg.Headers = make(map[string]string)
+		}
+		msg.Headers[key] = value
+	}
+}
+
+// WithHeaders sets several headers of the message.
+func WithHeaders(headers map[string]string) PublishOption {
+	return func(msg *Message) {
+		for k, v := range headers {
+			WithHeader(k, v)(msg)
+		}
+	}
+}
+
+// WithID sets the message id instead of a random uuid.
+func WithID(id string) PublishOption {
+	return func(msg *Message) {
+		if id != "" {
+			msg.ID = id
+		}
+	}
+}
+
+// WithTTL makes consumers discard the message unhandled if it was not delivered within ttl.
+// The ttl of a delayed message starts when it is published, not when it is due.
+func WithTTL(ttl time.Duration) PublishOption {
+	return func(msg *Message) {
+		msg.ExpireAt = unixMilli(time.Now().Add(ttl))
+	}
+}
+
+// WithExpireAt makes consumers discard the message unhandled if it was not delivered before t.
+func WithExpireAt(t time.Time) PublishOption {
+	return func(msg *Message) {
+		msg.ExpireAt = unixMilli(t)
+	}
+}
+
+// WithPriority sets the priority of the message, the message is put in the list of its priority.
+// Priorities need UseProducerPriorityLevels, without it every message is in the same list.
+func WithPriority(priority int) PublishOption {
+	return func(msg *Message) {
+		msg.Priority = priority
+	}
+}
+
+// WithContext sets the context the producer's propagator injects into the message headers.
+func WithContext(ctx context.Context) PublishOption {
+	return func(msg *Message) {
+		msg.ctx = ctx
+	}
+}
+
+func (p *Producer) newPublishMessage(body []byte, opts []PublishOption) *Message {
+	msg := NewMessage("", body)
+	for _, o := range opts {
+		o(msg)
+	}
+	p.inject(msg)
+	return msg
+}
@@ -0,0 +1,20 @@
+package redis_mq
+
+import (
+	"testing"
+)
+
+func TestListTarget(t *testing.T) {
+	p := NewProducer(nil)
+	for _, priority := range []int{0, 1, 5} {
+		if key := p.listTarget("topic", &Message{Priority: priority}); key != "topic:list" {
+			t.Fatalf("priority %d without levels in %s", priority, key)
+		}
+	}
+	p = NewProducer(nil, UseProducerPriorityLevels(3))
+	for priority, want := range map[int]string{-1: "topic:list", 0: "topic:list", 1: "topic:list:1", 2: "topic:list:2", 9: "topic:list:2"} {
+		if key := p.listTarget("topic", &Message{Priority: priority}); key != want {
+			t.Fatalf("priority %d in %s, want %s", priority, key, want)
+		}
+	}
+}
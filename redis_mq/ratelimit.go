@@ -0,0 +1,166 @@
+package redis_mq
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const rateLimitSuffix = ":ratelimit"
+
+// UseRateLimit limits the consumer to perSecond messages per second, allowing bursts of burst messages.
+func UseRateLimit(perSecond float64, burst int) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.RateLimit = perSecond
+		o.RateLimitBurst = burst
+		o.DistributedRateLimit = false
+	}
+}
+
+// UseDistributedRateLimit limits all consumers of the topic that use it together to perSecond
+// messages per second, with a token bucket stored in redis (<topic>:ratelimit).
+func UseDistributedRateLimit(perSecond float64, burst int) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.RateLimit = perSecond
+		o.RateLimitBurst = burst
+		o.DistributedRateLimit = true
+	}
+}
+
+// rateLimiter takes n tokens, going into debt if there are not enough, and returns the whole
+// tokens left or, if there are none, how long to wait until one is available.
+// Taking 0 tokens only looks at the bucket.
+type rateLimiter interface {
+	take(now time.Time, n int) (int, time.Duration, error)
+}
+
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(perSecond float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: perSecond, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (b *tokenBucket) take(now time.Time, n int) (int, time.Duration, error) {
+	b.Lock()
+	defer b.Unlock()
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 1 {
+		return int(b.tokens), 0, nil
+	}
+	return 0, time.Duration((1 - b.tokens) / b.rate * float64(time.Second)), nil
+}
+
+// rateLimitScript is a token bucket in a hash: ARGV are tokens per millisecond, burst, now in
+// milliseconds and the tokens to take. It returns the whole tokens left and the milliseconds
+// to wait until one is available.
+var rateLimitScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local v = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(v[1]) or burst
+local ts = tonumber(v[2]) or now
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate)
+	ts = now
+end
+tokens = tokens - n
+local wait = 0
+if tokens < 1 then
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1000)
+return {math.max(0, math.floor(tokens)), wait}
+`)
+
+type redisTokenBucket struct {
+	redisCmd redis.Cmdable
+	key      string
+	rate     float64 // tokens per millisecond
+	burst    int
+}
+
+func (b *redisTokenBucket) take(now time.Time, n int) (int, time.Duration, error) {
+	v, err := rateLimitScript.Run(b.redisCmd, []string{b.key}, b.rate, b.burst, unixMilli(now), n).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	rev, _ := v.([]interface{})
+	if len(rev) != 2 {
+		return 0, 0, errors.New("unexpected rate limit script reply")
+	}
+	tokens, _ := rev[0].(int64)
+	wait, _ := rev[1].(int64)
+	return int(tokens), time.Duration(wait) * time.Millisecond, nil
+}
+
+func (s *consumer) newRateLimiter() rateLimiter {
+	if s.options.RateLimit <= 0 {
+		return nil
+	}
+	if s.options.RateLimitBurst < 1 {
+		s.options.RateLimitBurst = 1
+	}
+	if s.options.DistributedRateLimit {
+		return &redisTokenBucket{
+			redisCmd: s.redisCmd,
+			key:      s.topicName + rateLimitSuffix,
+			rate:     s.options.RateLimit / 1000,
+			burst:    s.options.RateLimitBurst,
+		}
+	}
+	return newTokenBucket(s.options.RateLimit, s.options.RateLimitBurst)
+}
+
+// waitRateLimit blocks until the rate limit allows to fetch at least one message and returns
+// how many may be fetched, at most the batch size. It reports false if the consumer was stopped
+// in the meantime. The tokens are taken by takeRateLimit once the messages were fetched, so
+// empty polls do not use up the limit.
+func (s *consumer) waitRateLimit() (int, bool) {
+	size := s.handlers().fetchSize()
+	if s.limiter == nil {
+		return size, true
+	}
+	backoff := s.newPollBackoff()
+	for {
+		tokens, d, err := s.limiter.take(time.Now(), 0)
+		if err != nil {
+			s.reportError("rate limit", "", err)
+			d = backoff.next()
+		}
+		if err == nil && tokens > 0 {
+			if tokens > size {
+				tokens = size
+			}
+			return tokens, true
+		}
+		if !s.wait(d) {
+			return 0, false
+		}
+	}
+}
+
+// takeRateLimit takes a token for each fetched message.
+func (s *consumer) takeRateLimit(n int) {
+	if s.limiter == nil || n == 0 {
+		return
+	}
+	if _, _, err := s.limiter.take(time.Now(), n); err != nil {
+		s.reportError("rate limit", "", err)
+	}
+}
@@ -0,0 +1,62 @@
+package redis_mq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	now := time.Now()
+	if tokens, d, _ := b.take(now, 0); tokens != 2 || d != 0 {
+		t.Fatalf("full bucket: got %d tokens, wait %v", tokens, d)
+	}
+	if tokens, _, _ := b.take(now, 1); tokens != 1 {
+		t.Fatalf("took 1: got %d tokens", tokens)
+	}
+	if tokens, d, _ := b.take(now, 1); tokens != 0 || d != time.Millisecond*100 {
+		t.Errorf("empty bucket: got %d tokens, wait %v", tokens, d)
+	}
+	if tokens, _, _ := b.take(now.Add(time.Millisecond*100), 0); tokens != 1 {
+		t.Errorf("after refill: got %d tokens", tokens)
+	}
+	// more messages than tokens were fetched, the debt is paid before the next fetch
+	if tokens, d, _ := b.take(now.Add(time.Millisecond*100), 3); tokens != 0 || d != time.Millisecond*300 {
+		t.Errorf("debt: got %d tokens, wait %v", tokens, d)
+	}
+	if tokens, _, _ := b.take(now.Add(time.Hour), 0); tokens != 2 {
+		t.Errorf("refill is capped at burst: got %d tokens", tokens)
+	}
+}
+
+func TestWaitRateLimit(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return []interface{}{int64(3), int64(0)}
+	})
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic", UseDistributedRateLimit(10, 5))
+	s.limiter = s.newRateLimiter()
+	s.setBatchHandler(batchHandlerFunc(nil), 10)
+	// fetch at most the available tokens, without taking them
+	if limit, ok := s.waitRateLimit(); !ok || limit != 3 {
+		t.Fatalf("limit %d %v", limit, ok)
+	}
+	s.setBatchHandler(batchHandlerFunc(nil), 2)
+	if limit, _ := s.waitRateLimit(); limit != 2 {
+		t.Fatalf("limit %d", limit)
+	}
+	// the fetched messages are charged, empty fetches are not
+	s.takeRateLimit(0)
+	s.takeRateLimit(2)
+	evals := f.commands("EVAL")
+	if len(evals) != 3 {
+		t.Fatalf("evals %q", evals)
+	}
+	for i, n := range []string{"0", "0", "2"} {
+		if keys, argv := evalArgs(evals[i]); keys[0] != "topic:ratelimit" || argv[3] != n {
+			t.Fatalf("keys %q argv %q", keys, argv)
+		}
+	}
+}
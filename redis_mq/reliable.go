@@ -0,0 +1,134 @@
+package redis_mq
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// reliableFetchScript pops the head of the first non-empty list and records it in the unack zset
+// of the list, scored by the time its visibility timeout expires. KEYS are (list, unack) pairs.
+var reliableFetchScript = redis.NewScript(`
+for i = 1, #KEYS, 2 do
+	local v = redis.call('LPOP', KEYS[i])
+	if v then
+		redis.call('ZADD', KEYS[i + 1], ARGV[1], v)
+		return {(i - 1) / 2, v}
+	end
+end
+return false
+`)
+
+// requeueScript moves a message whose visibility timeout expired from the unack zset (KEYS[1])
+// back to the head of the list (KEYS[2]) as ARGV[2], the message with its attempts increased,
+// or to the dead letter list (KEYS[3]) as ARGV[3] when that is set. The ZREM makes sure only
+// one of several consumers moves it.
+var requeueScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+if ARGV[3] ~= '' then
+	redis.call('RPUSH', KEYS[3], ARGV[3])
+else
+	redis.call('LPUSH', KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+const requeueBatchSize = 100
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (s *consumer) fetchReliable() (string, int, error) {
+	levels := s.priorityLevels()
+	keys := make([]string, 0, levels*2)
+	for level := levels - 1; level >= 0; level-- {
+		keys = append(keys, s.listKey(level), s.unackKey(level))
+	}
+	deadline := unixMilli(time.Now().Add(s.options.VisibilityTimeout))
+	return popResult(reliableFetchScript.Run(s.redisCmd, keys, deadline), levels)
+}
+
+func (s *consumer) ack(level int, raw string) error {
+	return s.redisCmd.ZRem(s.unackKey(level), raw).Err()
+}
+
+// requeueExpired requeues or dead letters the messages whose visibility timeout expired.
+// It returns how many expired messages it found, at most requeueBatchSize per level.
+func (s *consumer) requeueExpired() (int64, error) {
+	var total int64
+	for level := s.priorityLevels() - 1; level >= 0; level-- {
+		msgs, err := s.redisCmd.ZRangeByScore(s.unackKey(level), redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(unixMilli(time.Now()), 10),
+			Count: requeueBatchSize,
+		}).Result()
+		if err != nil {
+			return total, err
+		}
+		for _, raw := range msgs {
+			if err := s.requeueUnacked(level, raw); err != nil {
+				return total, err
+			}
+		}
+		total += int64(len(msgs))
+	}
+	return total, nil
+}
+
+// requeueUnacked counts an expired visibility timeout as a failed attempt, like an error of
+// the handler, so a message that crashes its consumer is dead lettered after MaxAttempts.
+func (s *consumer) requeueUnacked(level int, raw string) error {
+	next, dead := raw, ""
+	msg := &Message{}
+	if err := decodeMessage(s.options.Codec, []byte(raw), msg); err == nil {
+		msg.Attempts++
+		if msg.Attempts >= s.options.Retry.MaxAttempts {
+			dead = encodeDeadLetter(msg.ID, []byte(raw), msg.Attempts, "visibility timeout exceeded")
+		} else if next, err = encodeMessage(s.options.Codec, msg); err != nil {
+			return err
+		}
+	}
+	keys := []string{s.unackKey(level), s.listKey(level), s.topicName + deadSuffix}
+	n, err := requeueScript.Run(s.redisCmd, keys, raw, next, dead).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 1 && dead != "" {
+		s.options.Metrics.DeadLettered(s.topicName)
+	}
+	return nil
+}
+
+func (s *consumer) startRequeueUnacked() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		interval := s.options.VisibilityTimeout / 2
+		if interval > time.Second {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				for {
+					n, err := s.requeueExpired()
+					if err != nil {
+						s.reportError("requeue unacked", "", err)
+						break
+					}
+					if n < requeueBatchSize*int64(s.priorityLevels()) {
+						break
+					}
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,50 @@
+package redis_mq
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRequeueExpired(t *testing.T) {
+	raw, _ := encodeMessage(JSONCodec, NewMessage("id", []byte("body")))
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		switch args[0] {
+		case "ZRANGEBYSCORE":
+			if args[1] == "topic:unack" {
+				return []interface{}{raw}
+			}
+			return []interface{}{}
+		case "EVAL":
+			return 1
+		}
+		return nil
+	})
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic", UseReliable(time.Second), UseRetry(2, nil))
+	if n, err := s.requeueExpired(); err != nil || n != 1 {
+		t.Fatalf("requeued %d %v", n, err)
+	}
+	// EVAL script numkeys unack list dead raw next dead
+	evals := f.commands("EVAL")
+	if len(evals) != 1 || evals[0][3] != "topic:unack" || evals[0][6] != raw || evals[0][8] != "" {
+		t.Fatalf("eval %q", evals)
+	}
+	msg := &Message{}
+	if err := decodeMessage(JSONCodec, []byte(evals[0][7]), msg); err != nil || msg.Attempts != 1 {
+		t.Fatalf("requeued message %+v %v", msg, err)
+	}
+
+	// the second expiry reaches MaxAttempts
+	raw = evals[0][7]
+	if _, err := s.requeueExpired(); err != nil {
+		t.Fatal(err)
+	}
+	evals = f.commands("EVAL")
+	d := &DeadLetter{}
+	if err := json.Unmarshal([]byte(evals[1][8]), d); err != nil || d.Attempts != 2 || d.ID != "id" || string(d.Payload) != raw {
+		t.Fatalf("dead letter %+v %v", d, err)
+	}
+}
@@ -0,0 +1,84 @@
+package redis_mq
+
+import (
+	"time"
+)
+
+// ErrorHandler is like Handler but reports whether the message was processed.
+// A nil error acks the message, a non-nil error schedules a retry (see UseRetry).
+type ErrorHandler interface {
+	HandleMessage(msg *Message) error
+}
+
+// Backoff returns how long to wait before the given retry attempt (starting at 1).
+type Backoff func(attempt int) time.Duration
+
+// ExponentialBackoff doubles the delay on every attempt, starting at initial and capped at max.
+func ExponentialBackoff(initial, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		d := initial
+		for i := 1; i < attempt; i++ {
+			d *= 2
+			if d >= max || d <= 0 {
+				return max
+			}
+		}
+		if d > max {
+			return max
+		}
+		return d
+	}
+}
+
+// DefaultMaxAttempts is the MaxAttempts of consumers without UseRetry.
+const DefaultMaxAttempts = 5
+
+type RetryOptions struct {
+	MaxAttempts int
+	Backoff     Backoff
+}
+
+// UseRetry makes the consumer re-deliver messages whose ErrorHandler returned an error,
+// through the topic's delay zset, until maxAttempts deliveries have failed.
+// The message is then moved to the topic's dead letter list.
+// Without UseRetry messages are tried DefaultMaxAttempts times with ExponentialBackoff(time.Second, time.Minute),
+// a maxAttempts of 1 disables retries.
+func UseRetry(maxAttempts int, backoff Backoff) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Retry.MaxAttempts = maxAttempts
+		o.Retry.Backoff = backoff
+	}
+}
+
+func (s *consumer) retry(msg *Message, handleErr error) {
+	if s.broadcast() {
+		s.reportError("handle", msg.ID, handleErr)
+		return
+	}
+	if s.useStream() {
+		// the message stays pending, claimPending delivers it again after the backoff,
+		// or dead letters it if this was the last attempt
+		if msg.Attempts+1 < s.options.Retry.MaxAttempts {
+			s.options.Metrics.Retried(s.topicName)
+		}
+		return
+	}
+	msg.Attempts++
+	if msg.Attempts >= s.options.Retry.MaxAttempts {
+		sendData, _ := s.options.Codec.Encode(msg)
+		if err := s.deadLetter(msg.ID, sendData, msg.Attempts, handleErr.Error()); err != nil {
+			s.reportError("dead letter", msg.ID, err)
+			return
+		}
+		msg.Ack()
+		return
+	}
+	s.options.Metrics.Retried(s.topicName)
+	at := time.Now().Add(s.options.Retry.Backoff(msg.Attempts))
+	if err := scheduleMessage(s.redisCmd, s.options.Codec, s.topicName, msg, at); err != nil {
+		// leave it unacked, a reliable consumer will deliver it again after the visibility timeout
+		s.reportError("retry", msg.ID, err)
+		return
+	}
+	msg.Ack()
+}
@@ -0,0 +1,55 @@
+package redis_mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(time.Second, time.Second*10)
+	expected := []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 8, time.Second * 10, time.Second * 10}
+	for i, d := range expected {
+		if got := backoff(i + 1); got != d {
+			t.Errorf("attempt %d: got %v want %v", i+1, got, d)
+		}
+	}
+	if got := backoff(100); got != time.Second*10 {
+		t.Errorf("attempt 100: got %v", got)
+	}
+}
+
+func TestDefaultRetry(t *testing.T) {
+	o := newConsumerOptions(nil)
+	if o.Retry.MaxAttempts != DefaultMaxAttempts || o.Retry.Backoff == nil {
+		t.Fatalf("retry options %+v", o.Retry)
+	}
+	o = newConsumerOptions([]ConsumerOption{UseRetry(1, nil)})
+	if o.Retry.MaxAttempts != 1 || o.Retry.Backoff == nil {
+		t.Fatalf("retry options %+v", o.Retry)
+	}
+}
+
+type countMetrics struct {
+	nopMetrics
+	retried int
+}
+
+func (m *countMetrics) Retried(topic string) {
+	m.retried++
+}
+
+func TestStreamRetryMetrics(t *testing.T) {
+	metrics := &countMetrics{}
+	s := NewSimpleMQConsumer(context.Background(), nil, "topic", UseStreamGroup("group", "c"),
+		UseRetry(3, nil), UseMetrics(metrics))
+	failed := errors.New("failed")
+	for attempts := 0; attempts < 3; attempts++ {
+		s.retry(&Message{Attempts: attempts}, failed)
+	}
+	// the third failure is dead lettered by claimPending instead of retried
+	if metrics.retried != 2 {
+		t.Fatalf("%d retries", metrics.retried)
+	}
+}
@@ -0,0 +1,342 @@
+package redis_mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// MessageTypeHeader is the header Router.HandleType routes on.
+const MessageTypeHeader = "type"
+
+// ErrNoRoute is returned for messages of a topic that has no handler for their type.
+// They are retried and dead lettered like messages whose handler failed.
+var ErrNoRoute = errors.New("no handler for message type")
+
+// WithType sets the MessageTypeHeader of the message.
+func WithType(msgType string) PublishOption {
+	return WithHeader(MessageTypeHeader, msgType)
+}
+
+// Router consumes many topics with one fetch loop: a BLPOP across the lists of all
+// topics (or a lua script for reliable routers), one delay scanner and one requeue loop
+// for all topics. Handlers are registered per topic, and optionally per message type.
+//
+// The consumer options apply to every topic. Streams, batch handlers and rate limits are
+// not supported; UseBLPop is implied unless the router is reliable. With redis cluster all
+// topics need the same hash tag, since the fetch reads the keys of all topics at once.
+type Router struct {
+	redisCmd redis.Cmdable
+	parent   context.Context
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	once     sync.Once
+	opts     []ConsumerOption
+	options  ConsumerOptions
+	routes   map[string]*route
+	topics   []string
+	keys     []string
+	keyRoute []routeKey
+	msgCh    chan []*Message
+	_        struct{}
+}
+
+type route struct {
+	*consumer
+	handler ErrorHandler
+	types   map[string]ErrorHandler
+}
+
+type routeKey struct {
+	route *route
+	level int
+}
+
+func NewRouter(parent context.Context, redisCmd redis.Cmdable, opts ...ConsumerOption) *Router {
+	ctx, cancel := context.WithCancel(parent)
+	opts = append(opts, func(o *ConsumerOptions) {
+		o.StreamGroup = ""
+	})
+	return &Router{
+		redisCmd: redisCmd,
+		parent:   parent,
+		ctx:      ctx,
+		cancel:   cancel,
+		opts:     opts,
+		options:  newConsumerOptions(opts),
+		routes:   make(map[string]*route),
+	}
+}
+
+// Handle sets the handler of a topic, used for messages without a type handler.
+// Handlers must be registered before Start.
+func (r *Router) Handle(topicName string, handler ErrorHandler) {
+	r.route(topicName).handler = handler
+}
+
+// HandleType sets the handler of the messages of a topic whose MessageTypeHeader is msgType.
+func (r *Router) HandleType(topicName, msgType string, handler ErrorHandler) {
+	r.route(topicName).types[msgType] = handler
+}
+
+func (r *Router) route(topicName string) *route {
+	if rt, ok := r.routes[topicName]; ok {
+		return rt
+	}
+	rt := &route{
+		// the route consumers only handle messages, Shutdown cancels their handler contexts
+		consumer: NewSimpleMQConsumer(r.parent, r.redisCmd, topicName, r.opts...),
+		types:    make(map[string]ErrorHandler),
+	}
+	rt.setHandler(nil, HandlerFunc(rt.handleMessage))
+	r.routes[topicName] = rt
+	r.topics = append(r.topics, topicName)
+	return rt
+}
+
+func (rt *route) handleMessage(msg *Message) error {
+	if h, ok := rt.types[msg.Headers[MessageTypeHeader]]; ok {
+		return h.HandleMessage(msg)
+	}
+	if rt.handler != nil {
+		return rt.handler.HandleMessage(msg)
+	}
+	return fmt.Errorf("%w: %q", ErrNoRoute, msg.Headers[MessageTypeHeader])
+}
+
+// Start starts consuming the registered topics.
+func (r *Router) Start() {
+	r.once.Do(func() {
+		if len(r.topics) == 0 {
+			return
+		}
+		// higher priorities of all topics first, topics in the order they were registered
+		for level := r.options.PriorityLevels - 1; level >= 0; level-- {
+			r.addKeys(level)
+		}
+		if r.options.PriorityLevels < 1 {
+			r.addKeys(0)
+		}
+		r.startWorkers()
+		r.startFetch()
+		r.startPromoteDelayMessage()
+		if r.options.Reliable {
+			r.startRequeueUnacked()
+		}
+	})
+}
+
+func (r *Router) addKeys(level int) {
+	for _, topicName := range r.topics {
+		rt := r.routes[topicName]
+		r.keys = append(r.keys, rt.listKey(level))
+		if r.options.Reliable {
+			r.keys = append(r.keys, rt.unackKey(level))
+		}
+		r.keyRoute = append(r.keyRoute, routeKey{route: rt, level: level})
+	}
+}
+
+func (r *Router) fetch() (*route, *Message, error) {
+	var raw string
+	var index int
+	if r.options.Reliable {
+		deadline := unixMilli(time.Now().Add(r.options.VisibilityTimeout))
+		var err error
+		if raw, index, err = popIndex(reliableFetchScript.Run(r.redisCmd, r.keys, deadline)); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		revs, err := r.redisCmd.BLPop(time.Second, r.keys...).Result()
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, key := range r.keys {
+			if key == revs[0] {
+				index = i
+			}
+		}
+		raw = revs[1]
+	}
+	k := r.keyRoute[index]
+	k.route.options.Metrics.Fetched(k.route.topicName, 1)
+	return k.route, k.route.newListMessage(raw, k.level), nil
+}
+
+func (r *Router) startFetch() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer r.options.Logger.Printf("stop router fetch.")
+		backoff := &pollBackoff{min: r.options.MinPollInterval, max: r.options.MaxPollInterval}
+		for r.ctx.Err() == nil {
+			rt, msg, err := r.fetch()
+			if err != nil {
+				if err != redis.Nil {
+					r.reportError("fetch", err)
+				} else if !r.options.Reliable {
+					// BLPOP already waited for a message
+					continue
+				}
+				if !sleepContext(r.ctx, backoff.next()) {
+					return
+				}
+				continue
+			}
+			backoff.reset()
+			if msg != nil {
+				r.deliver(rt, msg)
+			}
+		}
+	}()
+}
+
+func (r *Router) startWorkers() {
+	if r.options.Workers <= 1 {
+		return
+	}
+	r.msgCh = make(chan []*Message, r.options.BufferSize)
+	for i := 0; i < r.options.Workers; i++ {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for {
+				select {
+				case <-r.ctx.Done():
+					return
+				case msgs := <-r.msgCh:
+					rt := r.routes[msgs[0].topic]
+					if r.ctx.Err() != nil {
+						rt.release(msgs...)
+						return
+					}
+					rt.dispatchMessages(msgs)
+				}
+			}
+		}()
+	}
+}
+
+func (r *Router) deliver(rt *route, msg *Message) {
+	if r.msgCh == nil {
+		rt.dispatchMessages([]*Message{msg})
+		return
+	}
+	if r.ctx.Err() != nil {
+		rt.release(msg)
+		return
+	}
+	select {
+	case <-r.ctx.Done():
+		rt.release(msg)
+	case r.msgCh <- []*Message{msg}:
+	}
+}
+
+// startPromoteDelayMessage runs the delay scanner of all topics, sleeping until the
+// earliest next due message.
+func (r *Router) startPromoteDelayMessage() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			next, busy := r.options.MaxPollInterval, false
+			for _, topicName := range r.topics {
+				rt := r.routes[topicName]
+				n, d, err := rt.promoteDelayMessage()
+				if err != nil {
+					rt.reportError("promote delay message", "", err)
+					continue
+				}
+				busy = busy || n >= promoteBatchSize
+				if d < next {
+					next = d
+				}
+			}
+			if busy {
+				if r.ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+			if next < r.options.MinPollInterval {
+				next = r.options.MinPollInterval
+			}
+			if !sleepContext(r.ctx, next) {
+				return
+			}
+		}
+	}()
+}
+
+func (r *Router) startRequeueUnacked() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		interval := r.options.VisibilityTimeout / 2
+		if interval > time.Second {
+			interval = time.Second
+		}
+		for sleepContext(r.ctx, interval) {
+			for _, topicName := range r.topics {
+				rt := r.routes[topicName]
+				for {
+					n, err := rt.requeueExpired()
+					if err != nil {
+						rt.reportError("requeue unacked", "", err)
+						break
+					}
+					if n < requeueBatchSize*int64(rt.priorityLevels()) {
+						break
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Shutdown stops fetching and waits for the handlers like Consumer.Shutdown.
+func (r *Router) Shutdown(ctx context.Context) error {
+	r.cancel()
+	defer func() {
+		for _, rt := range r.routes {
+			rt.cancel()
+			rt.handlerCancel()
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+drain:
+	for {
+		select {
+		case msgs := <-r.msgCh:
+			r.routes[msgs[0].topic].release(msgs...)
+		default:
+			break drain
+		}
+	}
+	return err
+}
+
+func (r *Router) reportError(op string, err error) {
+	e := &Error{Op: op, Topic: strings.Join(r.topics, ","), Err: err}
+	r.options.Logger.Printf("%v \n", e)
+	if r.options.OnError != nil {
+		r.options.OnError(e)
+	}
+}
@@ -0,0 +1,47 @@
+package redis_mq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRouterKeys(t *testing.T) {
+	r := NewRouter(context.Background(), nil, UseReliable(0), UsePriorityLevels(2))
+	r.Handle("a", HandlerFunc(func(msg *Message) error { return nil }))
+	r.Handle("b", HandlerFunc(func(msg *Message) error { return nil }))
+	r.addKeys(1)
+	r.addKeys(0)
+	want := "a:list:1,a:unack:1,b:list:1,b:unack:1,a:list,a:unack,b:list,b:unack"
+	if got := strings.Join(r.keys, ","); got != want {
+		t.Fatalf("keys %s", got)
+	}
+	if k := r.keyRoute[3]; k.route.topicName != "b" || k.level != 0 {
+		t.Fatalf("key route %s %d", k.route.topicName, k.level)
+	}
+}
+
+func TestRouterHandleType(t *testing.T) {
+	r := NewRouter(context.Background(), nil)
+	var got string
+	r.HandleType("orders", "created", HandlerFunc(func(msg *Message) error {
+		got = "created"
+		return nil
+	}))
+	rt := r.routes["orders"]
+	handle := rt.chain.Load().(handlerChain).handler
+	if err := handle.HandleMessage(&Message{Headers: map[string]string{MessageTypeHeader: "created"}}); err != nil || got != "created" {
+		t.Fatalf("err %v handled %q", err, got)
+	}
+	if err := handle.HandleMessage(&Message{}); !errors.Is(err, ErrNoRoute) {
+		t.Fatalf("err %v", err)
+	}
+	r.Handle("orders", HandlerFunc(func(msg *Message) error {
+		got = "default"
+		return nil
+	}))
+	if err := handle.HandleMessage(&Message{}); err != nil || got != "default" {
+		t.Fatalf("err %v handled %q", err, got)
+	}
+}
@@ -0,0 +1,132 @@
+package redis_mq
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// ReplyToHeader is the list a responder pushes the reply of a request to.
+	ReplyToHeader = "replyTo"
+	// CorrelationIDHeader is set on a request and on its reply.
+	CorrelationIDHeader = "correlationId"
+	// ErrorHeader is set on a reply when the responder returned an error.
+	ErrorHeader = "error"
+
+	replySuffix       = ":reply:"
+	replyTTL          = time.Minute
+	replyPollInterval = time.Millisecond * 10
+)
+
+// RemoteError is returned by Request when the responder returned an error.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "redis_mq: remote error: " + e.Message
+}
+
+// Request publishes a request to the topic and waits for its reply until ctx is done.
+// The reply is pushed by a consumer with SetResponder to the <topic>:reply:<correlation id>
+// list. A ctx deadline is also the expiry of the request, so responders skip requests
+// nobody waits for anymore. ctx is checked every second while waiting, and every
+// replyPollInterval during the last second before its deadline.
+func (p *Producer) Request(ctx context.Context, topicName string, body []byte, opts ...PublishOption) (*Message, error) {
+	correlationID := uuid.NewV4().String()
+	replyTo := topicName + replySuffix + correlationID
+	opts = append(opts, WithContext(ctx), WithHeader(ReplyToHeader, replyTo), WithHeader(CorrelationIDHeader, correlationID))
+	if deadline, ok := ctx.Deadline(); ok {
+		opts = append(opts, WithExpireAt(deadline))
+	}
+	if err := p.Publish(topicName, body, opts...); err != nil {
+		return nil, err
+	}
+	defer p.redisCmd.Del(replyTo)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		raw, err := p.popReply(ctx, replyTo)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, p.reportError("request", topicName, correlationID, err)
+		}
+		reply := &Message{}
+		if err := decodeMessage(p.options.Codec, []byte(raw), reply); err != nil {
+			return nil, p.reportError("request", topicName, correlationID, err)
+		}
+		if msg, ok := reply.Headers[ErrorHeader]; ok {
+			return reply, &RemoteError{Message: msg}
+		}
+		return reply, nil
+	}
+}
+
+// popReply waits at most a second for the reply. BLPOP only takes whole seconds (and blocks
+// forever for less than one), so the last second before the ctx deadline is polled with LPOP.
+func (p *Producer) popReply(ctx context.Context, replyTo string) (string, error) {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < time.Second {
+		raw, err := p.redisCmd.LPop(replyTo).Result()
+		if err == redis.Nil {
+			sleepContext(ctx, replyPollInterval)
+		}
+		return raw, err
+	}
+	revs, err := p.redisCmd.BLPop(time.Second, replyTo).Result()
+	if err != nil {
+		return "", err
+	}
+	return revs[1], nil
+}
+
+// Responder handles requests, the returned body or error is sent back to the requester.
+type Responder interface {
+	Respond(ctx context.Context, msg *Message) ([]byte, error)
+}
+
+// ResponderFunc adapts a function to a Responder.
+type ResponderFunc func(ctx context.Context, msg *Message) ([]byte, error)
+
+func (f ResponderFunc) Respond(ctx context.Context, msg *Message) ([]byte, error) {
+	return f(ctx, msg)
+}
+
+// SetResponder handles requests sent with Producer.Request. The error of the responder is
+// sent back as a RemoteError and the request is acked; a request is retried only when the
+// reply can not be pushed. Messages without ReplyToHeader are handled like with
+// SetContextHandler.
+func (s *consumer) SetResponder(responder Responder) {
+	s.SetContextHandler(ContextHandlerFunc(func(ctx context.Context, msg *Message) error {
+		body, err := responder.Respond(ctx, msg)
+		replyTo := msg.Headers[ReplyToHeader]
+		if replyTo == "" {
+			return err
+		}
+		return s.reply(replyTo, msg.Headers[CorrelationIDHeader], body, err)
+	}))
+}
+
+func (s *consumer) reply(replyTo, correlationID string, body []byte, respondErr error) error {
+	reply := NewMessage("", body)
+	reply.Headers = map[string]string{CorrelationIDHeader: correlationID}
+	if respondErr != nil {
+		reply.Headers[ErrorHeader] = respondErr.Error()
+	}
+	sendData, err := encodeMessage(s.options.Codec, reply)
+	if err != nil {
+		return err
+	}
+	// the reply list expires in case the requester gave up
+	_, err = s.redisCmd.Pipelined(func(pip redis.Pipeliner) error {
+		pip.RPush(replyTo, sendData)
+		pip.Expire(replyTo, replyTTL)
+		return nil
+	})
+	return err
+}
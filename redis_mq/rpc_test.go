@@ -0,0 +1,114 @@
+package redis_mq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReply(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return 1
+	})
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic")
+	if err := s.reply("topic:reply:c", "c", []byte("body"), errors.New("failed")); err != nil {
+		t.Fatal(err)
+	}
+	pushes := f.commands("RPUSH")
+	if len(pushes) != 1 || pushes[0][1] != "topic:reply:c" {
+		t.Fatalf("pushes %q", pushes)
+	}
+	reply := &Message{}
+	if err := decodeMessage(JSONCodec, []byte(pushes[0][2]), reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply.Body) != "body" || reply.Headers[CorrelationIDHeader] != "c" || reply.Headers[ErrorHeader] != "failed" {
+		t.Fatalf("reply %+v", reply)
+	}
+	// the reply list expires in case nobody waits for it
+	if expires := f.commands("EXPIRE"); len(expires) != 1 || expires[0][1] != "topic:reply:c" {
+		t.Fatalf("expires %q", expires)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	var mu sync.Mutex
+	var request *Message
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		switch args[0] {
+		case "RPUSH":
+			request = &Message{}
+			decodeMessage(JSONCodec, []byte(args[2]), request)
+			return 1
+		case "LPOP", "BLPOP":
+			if request == nil || args[1] != request.Headers[ReplyToHeader] {
+				return nil
+			}
+			reply := NewMessage("", []byte("reply"))
+			reply.Headers = map[string]string{CorrelationIDHeader: request.Headers[CorrelationIDHeader], ErrorHeader: "failed"}
+			sendData, _ := encodeMessage(JSONCodec, reply)
+			if args[0] == "BLPOP" {
+				return []interface{}{args[1], sendData}
+			}
+			return sendData
+		}
+		return 1
+	})
+	defer f.Close()
+
+	p := NewProducer(client)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	reply, err := p.Request(ctx, "topic", []byte("body"))
+	if e, ok := err.(*RemoteError); !ok || e.Message != "failed" {
+		t.Fatalf("err %#v", err)
+	}
+	if string(reply.Body) != "reply" || reply.Headers[CorrelationIDHeader] != request.Headers[CorrelationIDHeader] {
+		t.Fatalf("reply %+v", reply)
+	}
+	if request.Headers[ReplyToHeader] != "topic:reply:"+request.Headers[CorrelationIDHeader] || request.ExpireAt == 0 {
+		t.Fatalf("request %+v", request)
+	}
+	if dels := f.commands("DEL"); len(dels) != 1 || dels[0][1] != request.Headers[ReplyToHeader] {
+		t.Fatalf("dels %q", dels)
+	}
+
+	// with less than a second left the reply is polled
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+	if _, err := p.Request(ctx, "topic", []byte("body")); err == nil {
+		t.Fatal("expected the remote error")
+	}
+	if lpops := f.commands("LPOP"); len(lpops) != 1 {
+		t.Fatalf("lpops %q", lpops)
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		if args[0] == "LPOP" {
+			return nil
+		}
+		return 1
+	})
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	if _, err := NewProducer(client).Request(ctx, "topic", []byte("body")); err != context.DeadlineExceeded {
+		t.Fatalf("err %v", err)
+	}
+	if d := time.Since(start); d > time.Millisecond*500 {
+		t.Fatalf("returned after %v", d)
+	}
+	if blpops := f.commands("BLPOP"); len(blpops) != 0 {
+		t.Fatalf("blpops %q", blpops)
+	}
+}
@@ -0,0 +1,246 @@
+package redis_mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	schedulesSuffix, leaderSuffix = ":schedules", ":leader"
+	schedulerTick                 = time.Second
+	schedulerLockTTL              = time.Second * 5
+)
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule is a recurring message, stored in the <scheduler>:schedules hash.
+// Next is the unix milliseconds of the next occurrence.
+type Schedule struct {
+	Name     string        `json:"name"`
+	Topic    string        `json:"topic"`
+	Body     []byte        `json:"body"`
+	Cron     string        `json:"cron,omitempty"`
+	Interval time.Duration `json:"interval,omitempty"`
+	Next     int64         `json:"next"`
+}
+
+func (s *Schedule) next(after time.Time) (time.Time, error) {
+	if s.Cron != "" {
+		c, err := parseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		next := c.Next(after)
+		if next.IsZero() {
+			return next, errors.New("cron expression has no next occurrence")
+		}
+		return next, nil
+	}
+	if s.Interval <= 0 {
+		return time.Time{}, errors.New("schedule needs a cron expression or an interval")
+	}
+	return after.Add(s.Interval), nil
+}
+
+func (s *Schedule) sameDefinition(o *Schedule) bool {
+	return s.Topic == o.Topic && string(s.Body) == string(o.Body) && s.Cron == o.Cron && s.Interval == o.Interval
+}
+
+// leaderScript takes or renews the leader lock.
+var leaderScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+var releaseLeaderScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// updateScheduleScript saves a schedule only if it was not removed in the meantime.
+var updateScheduleScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 1 then
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// Scheduler publishes recurring messages to the delay zset of their topic.
+// Schedules are stored in redis, every scheduler with the same name shares them, and only
+// the one holding the leader lock enqueues occurrences, so they do not fire twice.
+type Scheduler struct {
+	redisCmd   redis.Cmdable
+	ctx        context.Context
+	name       string
+	instanceID string
+	options    SchedulerOptions
+	_          struct{}
+}
+
+type SchedulerOptions struct {
+	Logger  Logger
+	OnError func(err error)
+}
+
+type SchedulerOption func(options *SchedulerOptions)
+
+func NewScheduler(ctx context.Context, redisCmd redis.Cmdable, name string, opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		redisCmd:   redisCmd,
+		ctx:        ctx,
+		name:       name,
+		instanceID: uuid.NewV4().String(),
+	}
+	for _, o := range opts {
+		o(&s.options)
+	}
+	if s.options.Logger == nil {
+		s.options.Logger = stdLogger{}
+	}
+	s.start()
+	return s
+}
+
+// AddCron registers a message published to topic at every time matching the cron expression.
+func (s *Scheduler) AddCron(name, topic, spec string, body []byte) error {
+	if _, err := parseCron(spec); err != nil {
+		return err
+	}
+	return s.add(&Schedule{Name: name, Topic: topic, Body: body, Cron: spec})
+}
+
+// AddInterval registers a message published to topic every interval.
+func (s *Scheduler) AddInterval(name, topic string, interval time.Duration, body []byte) error {
+	if interval <= 0 {
+		return errors.New("interval need great than zero")
+	}
+	return s.add(&Schedule{Name: name, Topic: topic, Body: body, Interval: interval})
+}
+
+func (s *Scheduler) add(sch *Schedule) error {
+	// registering the same schedule again, e.g. on every instance start, keeps its next occurrence
+	if old, err := s.Get(sch.Name); err == nil && old.sameDefinition(sch) {
+		return nil
+	}
+	next, err := sch.next(time.Now())
+	if err != nil {
+		return err
+	}
+	sch.Next = unixMilli(next)
+	sendData, _ := json.Marshal(sch)
+	return s.redisCmd.HSet(s.name+schedulesSuffix, sch.Name, string(sendData)).Err()
+}
+
+func (s *Scheduler) Remove(name string) error {
+	return s.redisCmd.HDel(s.name+schedulesSuffix, name).Err()
+}
+
+func (s *Scheduler) Get(name string) (*Schedule, error) {
+	v, err := s.redisCmd.HGet(s.name+schedulesSuffix, name).Result()
+	if err == redis.Nil {
+		return nil, ErrScheduleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	sch := &Schedule{}
+	if err := json.Unmarshal([]byte(v), sch); err != nil {
+		return nil, err
+	}
+	return sch, nil
+}
+
+func (s *Scheduler) List() ([]*Schedule, error) {
+	values, err := s.redisCmd.HGetAll(s.name + schedulesSuffix).Result()
+	if err != nil {
+		return nil, err
+	}
+	rev := make([]*Schedule, 0, len(values))
+	for _, v := range values {
+		sch := &Schedule{}
+		if err := json.Unmarshal([]byte(v), sch); err != nil {
+			return nil, err
+		}
+		rev = append(rev, sch)
+	}
+	return rev, nil
+}
+
+func (s *Scheduler) start() {
+	go func() {
+		ticker := time.NewTicker(schedulerTick)
+		defer func() {
+			ticker.Stop()
+			releaseLeaderScript.Run(s.redisCmd, []string{s.name + leaderSuffix}, s.instanceID)
+		}()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				leader, err := leaderScript.Run(s.redisCmd, []string{s.name + leaderSuffix}, s.instanceID, int64(schedulerLockTTL/time.Millisecond)).Int64()
+				if err != nil {
+					s.reportError("leader lock", err)
+					continue
+				}
+				if leader != 1 {
+					continue
+				}
+				if err := s.enqueueDue(); err != nil {
+					s.reportError("enqueue", err)
+				}
+			}
+		}
+	}()
+}
+
+// enqueueDue puts the occurrences due before the next tick into the delay zset of their topic.
+// The message id is derived from the schedule name and occurrence time, so enqueueing the
+// same occurrence twice only overwrites it.
+func (s *Scheduler) enqueueDue() error {
+	schedules, err := s.List()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, sch := range schedules {
+		if sch.Next > unixMilli(now.Add(schedulerTick)) {
+			continue
+		}
+		at := time.Unix(0, sch.Next*int64(time.Millisecond))
+		msg := NewMessage(sch.Name+":"+strconv.FormatInt(sch.Next, 10), sch.Body)
+		if err := scheduleMessage(s.redisCmd, JSONCodec, sch.Topic, msg, at); err != nil {
+			return err
+		}
+		// of the occurrences missed while no scheduler was running only the oldest one is published,
+		// right away, the next occurrence is counted from now
+		if now.After(at) {
+			at = now
+		}
+		next, err := sch.next(at)
+		if err != nil {
+			return err
+		}
+		sch.Next = unixMilli(next)
+		sendData, _ := json.Marshal(sch)
+		if err := updateScheduleScript.Run(s.redisCmd, []string{s.name + schedulesSuffix}, sch.Name, string(sendData)).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package redis_mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerOnError(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return errors.New("ERR down")
+	})
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 10)
+	logger := &recordLogger{}
+	NewScheduler(ctx, client, "scheduler", UseSchedulerLogger(logger), OnSchedulerError(func(err error) {
+		errs <- err
+	}))
+	select {
+	case err := <-errs:
+		e, ok := err.(*Error)
+		if !ok || e.Op != "leader lock" || e.Topic != "scheduler" {
+			t.Fatalf("err %#v", err)
+		}
+	case <-time.After(schedulerTick * 3):
+		t.Fatal("no error reported")
+	}
+	if logger.count() == 0 {
+		t.Fatal("error not logged")
+	}
+}
+
+type recordLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *recordLogger) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.lines)
+}
+
+func TestEnqueueDue(t *testing.T) {
+	now := time.Now()
+	missed := &Schedule{Name: "missed", Topic: "topic", Body: []byte("a"), Interval: time.Minute, Next: unixMilli(now.Add(-time.Hour))}
+	later := &Schedule{Name: "later", Topic: "topic", Body: []byte("b"), Interval: time.Minute, Next: unixMilli(now.Add(time.Hour))}
+	var values []interface{}
+	for _, sch := range []*Schedule{missed, later} {
+		data, _ := json.Marshal(sch)
+		values = append(values, sch.Name, string(data))
+	}
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		if args[0] == "HGETALL" {
+			return values
+		}
+		return 1
+	})
+	defer f.Close()
+
+	s := &Scheduler{redisCmd: client, name: "scheduler"}
+	if err := s.enqueueDue(); err != nil {
+		t.Fatal(err)
+	}
+	evals := f.commands("EVAL")
+	if len(evals) != 2 {
+		t.Fatalf("evals %q", evals)
+	}
+	// the oldest missed occurrence is scheduled at its time, so it fires right away
+	keys, argv := evalArgs(evals[0])
+	if keys[0] != "topic:zset" || argv[0] != strconv.FormatInt(missed.Next, 10) || argv[1] != "missed:"+argv[0] {
+		t.Fatalf("keys %q argv %q", keys, argv)
+	}
+	// the next occurrence is counted from now, the missed ones in between are skipped.
+	// The schedule is only updated if it still exists, so a removed schedule is not added back.
+	keys, argv = evalArgs(evals[1])
+	updated := &Schedule{}
+	json.Unmarshal([]byte(argv[1]), updated)
+	if keys[0] != "scheduler:schedules" || argv[0] != "missed" || !strings.Contains(evals[1][1], "HEXISTS") ||
+		updated.Next < unixMilli(now.Add(time.Minute)) || updated.Next > unixMilli(time.Now().Add(time.Minute)) {
+		t.Fatalf("keys %q argv %q", keys, argv)
+	}
+	if sets := f.commands("HSET"); len(sets) != 0 {
+		t.Fatalf("sets %q", sets)
+	}
+}
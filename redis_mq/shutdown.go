@@ -0,0 +1,76 @@
+package redis_mq
+
+import (
+	"context"
+
+	"github.com/go-redis/redis"
+)
+
+// releaseScript removes a message from the unack set (if it is there) and pushes it back to the head of the list.
+var releaseScript = redis.NewScript(`
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('LPUSH', KEYS[2], ARGV[1])
+return 1
+`)
+
+// Shutdown stops fetching messages and waits until the handlers finished the messages
+// in flight, or ctx is done. The context of the messages in flight is cancelled only then,
+// and a message whose handler fails after that is pushed back to redis instead of being retried.
+// Prefetched messages that were not handled are pushed back to redis as well.
+// Stream messages that were not handled stay pending and are claimed after the visibility timeout,
+// broadcast messages are dropped.
+func (s *consumer) Shutdown(ctx context.Context) error {
+	s.cancel()
+	defer s.handlerCancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	var prefetched []*Message
+drain:
+	for {
+		select {
+		case msgs := <-s.msgCh:
+			prefetched = append(prefetched, msgs...)
+		default:
+			break drain
+		}
+	}
+	s.release(prefetched...)
+	return err
+}
+
+// retryOrRelease retries a message whose handler failed, unless the handler context was
+// cancelled: the consumer is shutting down and the message did not fail by itself.
+func (s *consumer) retryOrRelease(msg *Message, handleErr error) {
+	if s.handlerCtx.Err() != nil {
+		s.release(msg)
+		return
+	}
+	s.retry(msg, handleErr)
+}
+
+// release pushes messages back to the head of their list.
+func (s *consumer) release(msgs ...*Message) {
+	if s.useStream() || s.broadcast() {
+		return
+	}
+	// LPUSH in reverse, so the messages keep their order at the head of the list
+	for i := len(msgs) - 1; i >= 0; i-- {
+		msg := msgs[i]
+		raw := msg.raw
+		if raw == "" {
+			raw, _ = encodeMessage(s.options.Codec, msg)
+		}
+		if err := releaseScript.Run(s.redisCmd, []string{s.unackKey(msg.level), s.listKey(msg.level)}, raw).Err(); err != nil {
+			s.reportError("release", msg.ID, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package redis_mq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReleaseOrder(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return 1
+	})
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic", UseReliable(time.Second), UsePriorityLevels(2))
+	first := &Message{ID: "1", raw: "raw1"}
+	second := &Message{ID: "2", raw: "raw2", level: 1}
+	third := NewMessage("3", []byte("body"))
+	s.release(first, second, third)
+
+	// EVAL script numkeys unack list raw, LPUSHed in reverse to keep the order at the head
+	evals := f.commands("EVAL")
+	if len(evals) != 3 {
+		t.Fatalf("evals %q", evals)
+	}
+	if evals[2][3] != "topic:unack" || evals[2][4] != "topic:list" || evals[2][5] != "raw1" {
+		t.Fatalf("first released %q", evals[2])
+	}
+	if evals[1][3] != "topic:unack:1" || evals[1][4] != "topic:list:1" || evals[1][5] != "raw2" {
+		t.Fatalf("second released %q", evals[1])
+	}
+	// messages without the raw value they were fetched as are encoded again
+	msg := &Message{}
+	if err := decodeMessage(JSONCodec, []byte(evals[0][5]), msg); err != nil || msg.ID != "3" {
+		t.Fatalf("third released %q", evals[0])
+	}
+}
+
+func TestShutdownInFlight(t *testing.T) {
+	raw, _ := encodeMessage(JSONCodec, NewMessage("id", []byte("body")))
+	for _, expire := range []bool{false, true} {
+		popped := false
+		f, client := newFakeRedis(t, func(args []string) interface{} {
+			switch args[0] {
+			case "LPOP":
+				if !popped {
+					popped = true
+					return raw
+				}
+				return nil
+			case "EVAL":
+				return []interface{}{int64(0), ""}
+			}
+			return 1
+		})
+
+		s := NewSimpleMQConsumer(context.Background(), client, "topic")
+		handling := make(chan struct{})
+		finish := make(chan struct{})
+		handled := make(chan error, 1)
+		s.SetContextHandler(ContextHandlerFunc(func(ctx context.Context, msg *Message) error {
+			close(handling)
+			var err error
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-finish:
+			}
+			handled <- err
+			return err
+		}))
+		<-handling
+
+		timeout := time.Second
+		if expire {
+			timeout = time.Millisecond * 50
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		shutdown := make(chan error, 1)
+		go func() {
+			shutdown <- s.Shutdown(ctx)
+		}()
+		if !expire {
+			// the handler keeps its context while Shutdown waits for it
+			time.Sleep(time.Millisecond * 50)
+			close(finish)
+		}
+		if err := <-handled; (err != nil) != expire {
+			t.Fatalf("expire %v: handler err %v", expire, err)
+		}
+		if err := <-shutdown; (err == context.DeadlineExceeded) != expire {
+			t.Fatalf("expire %v: shutdown err %v", expire, err)
+		}
+		cancel()
+
+		// a handler failing because of the shutdown releases the message, it is not retried
+		var released bool
+		polls := 1
+		if expire {
+			polls = 100
+		}
+		for i := 0; i < polls && !released; i++ {
+			for _, eval := range f.commands("EVAL") {
+				keys, argv := evalArgs(eval)
+				released = released || (keys[0] == "topic:unack" && argv[0] == raw)
+				if len(keys) == 3 {
+					t.Fatalf("expire %v: message scheduled for a retry", expire)
+				}
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		if released != expire {
+			t.Fatalf("expire %v: released %v", expire, released)
+		}
+		f.Close()
+	}
+}
+
+func TestDeliverAfterStop(t *testing.T) {
+	f, client := newFakeRedis(t, func(args []string) interface{} {
+		return 1
+	})
+	defer f.Close()
+
+	// the workers stopped, a message fetched after that goes back to the list even
+	// though the buffer has room
+	s := NewSimpleMQConsumer(context.Background(), client, "topic", UseWorkers(2, 10))
+	s.startWorkers()
+	s.cancel()
+	s.wg.Wait()
+	for i := 0; i < 10; i++ {
+		s.deliver(&Message{ID: "id", raw: "raw"})
+	}
+	if len(s.msgCh) != 0 {
+		t.Fatalf("%d messages left in the buffer", len(s.msgCh))
+	}
+	if evals := f.commands("EVAL"); len(evals) != 10 {
+		t.Fatalf("%d messages released", len(evals))
+	}
+}
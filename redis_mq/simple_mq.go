@@ -2,11 +2,9 @@ package redis_mq
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"log"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -15,13 +13,27 @@ import (
 
 const (
 	listSuffix, zsetSuffix = ":list", ":zset"
+	unackSuffix            = ":unack"
+	delayMsgSuffix         = ":zset:msgs"
+	delayLevelSuffix       = ":zset:levels"
 )
 
 type Message struct {
-	ID        string `json:"id"`
-	Body      []byte `json:"body"`
-	Timestamp int64  `json:"timestamp"`
-	DelayTime int64  `json:"delayTime"`
+	ID        string            `json:"id"`
+	Body      []byte            `json:"body"`
+	Timestamp int64             `json:"timestamp"`
+	DelayTime int64             `json:"delayTime"`
+	Attempts  int               `json:"attempts,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	ExpireAt  int64             `json:"expireAt,omitempty"`
+	Priority  int               `json:"priority,omitempty"`
+	raw       string
+	topic     string
+	level     int
+	fetchedAt time.Time
+	ctx       context.Context
+	cancel    context.CancelFunc
+	ack       func() error
 	_         struct{}
 }
 
@@ -37,6 +49,49 @@ func NewMessage(id string, body []byte) *Message {
 	}
 }
 
+// Expired reports whether the message has an expiry (unix milliseconds) that has passed.
+func (m *Message) Expired() bool {
+	return m.ExpireAt > 0 && unixMilli(time.Now()) > m.ExpireAt
+}
+
+// Topic returns the topic the message was received from.
+func (m *Message) Topic() string {
+	return m.topic
+}
+
+// Context returns the context of the message while it is handled. It is derived from the
+// consumer context, has the processing deadline, and the trace context extracted from the
+// headers when the consumer uses a Propagator.
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// Ack removes the message from the unack set of a reliable consumer.
+// Messages that are not acked within the visibility timeout are delivered again.
+// It is a no-op for messages received without UseReliable.
+func (m *Message) Ack() error {
+	if m.ack == nil {
+		return nil
+	}
+	return m.ack()
+}
+
+// clone copies the message, with its own body and headers.
+func (m *Message) clone() *Message {
+	cp := *m
+	cp.Body = append([]byte(nil), m.Body...)
+	if m.Headers != nil {
+		cp.Headers = make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			cp.Headers[k] = v
+		}
+	}
+	return &cp
+}
+
 type Handler interface {
 	HandleMessage(msg *Message)
 }
@@ -44,21 +99,57 @@ type Handler interface {
 type consumer struct {
 	once            sync.Once
 	redisCmd        redis.Cmdable
+	subscriber      redis.UniversalClient
 	ctx             context.Context
+	cancel          context.CancelFunc
+	handlerCtx      context.Context
+	handlerCancel   context.CancelFunc
+	wg              sync.WaitGroup
 	topicName       string
+	handlerMu       sync.Mutex // guards the handlers, which are read through chain
 	handler         Handler
+	errHandler      ErrorHandler
+	chain           atomic.Value // handlerChain
+	batchHandler    BatchHandler
+	batchSize       int
+	msgCh           chan []*Message
+	limiter         rateLimiter
 	rateLimitPeriod time.Duration
 	options         ConsumerOptions
 	_               struct{}
 }
 
 type ConsumerOptions struct {
-	RateLimitPeriod time.Duration
-	UseBLPop        bool
+	RateLimitPeriod      time.Duration
+	MinPollInterval      time.Duration
+	MaxPollInterval      time.Duration
+	UseBLPop             bool
+	Reliable             bool
+	VisibilityTimeout    time.Duration
+	Retry                RetryOptions
+	StreamGroup          string
+	StreamConsumer       string
+	Workers              int
+	BufferSize           int
+	Codec                Codec
+	PriorityLevels       int
+	DedupWindow          time.Duration
+	RateLimit            float64
+	RateLimitBurst       int
+	DistributedRateLimit bool
+	Logger               Logger
+	OnError              func(err error)
+	Metrics              Metrics
+	Propagator           Propagator
+	Middlewares          []Middleware
+	ProcessingTimeout    time.Duration
 }
 
 type ConsumerOption func(options *ConsumerOptions)
 
+// NewRateLimitPeriod sets the max wait between polls of an empty topic.
+//
+// Deprecated: use NewPollInterval, or UseRateLimit to limit the rate of handled messages.
 func NewRateLimitPeriod(d time.Duration) ConsumerOption {
 	return func(o *ConsumerOptions) {
 		o.RateLimitPeriod = d
@@ -71,139 +162,286 @@ func UseBLPop(u bool) ConsumerOption {
 	}
 }
 
+// UseReliable enables at-least-once delivery: a fetched message is kept in the
+// topic's unack set until Message.Ack is called, and is pushed back to the list
+// if it is not acked within visibilityTimeout. An expired visibility timeout counts as
+// a failed attempt, see UseRetry. UseBLPop is ignored in this mode.
+func UseReliable(visibilityTimeout time.Duration) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Reliable = true
+		o.VisibilityTimeout = visibilityTimeout
+	}
+}
+
 type Consumer = *consumer
 
+// NewSimpleMQConsumer creates a consumer of the topic. Cancelling ctx stops it right away,
+// including the context of the messages in flight; use Shutdown to stop it gracefully.
 func NewSimpleMQConsumer(ctx context.Context, redisCmd redis.Cmdable, topicName string, opts ...ConsumerOption) Consumer {
+	// the fetch loops stop with ctx, the handlers with handlerCtx, which Shutdown only
+	// cancels once its deadline passed
+	handlerCtx, handlerCancel := context.WithCancel(ctx)
+	ctx, cancel := context.WithCancel(ctx)
 	consumer := &consumer{
-		redisCmd:  redisCmd,
-		ctx:       ctx,
-		topicName: topicName,
+		redisCmd:      redisCmd,
+		ctx:           ctx,
+		cancel:        cancel,
+		handlerCtx:    handlerCtx,
+		handlerCancel: handlerCancel,
+		topicName:     topicName,
+		batchSize:     1,
+		options:       newConsumerOptions(opts),
 	}
-	for _, o := range opts {
-		o(&consumer.options)
+	return consumer
+}
+
+func newConsumerOptions(opts []ConsumerOption) ConsumerOptions {
+	o := ConsumerOptions{}
+	for _, opt := range opts {
+		opt(&o)
 	}
-	if consumer.options.RateLimitPeriod == 0 {
-		consumer.options.RateLimitPeriod = time.Microsecond * 200
+	if o.MaxPollInterval <= 0 {
+		o.MaxPollInterval = o.RateLimitPeriod
 	}
-	return consumer
+	if o.MaxPollInterval <= 0 {
+		o.MaxPollInterval = time.Millisecond * 100
+	}
+	if o.MinPollInterval <= 0 {
+		o.MinPollInterval = time.Millisecond
+	}
+	if o.MinPollInterval > o.MaxPollInterval {
+		o.MinPollInterval = o.MaxPollInterval
+	}
+	if (o.Reliable || o.StreamGroup != "") && o.VisibilityTimeout <= 0 {
+		o.VisibilityTimeout = time.Second * 30
+	}
+	if o.Retry.MaxAttempts < 1 {
+		o.Retry.MaxAttempts = DefaultMaxAttempts
+	}
+	if o.Metrics == nil {
+		o.Metrics = nopMetrics{}
+	}
+	if o.Logger == nil {
+		o.Logger = stdLogger{}
+	}
+	if o.Codec == nil {
+		o.Codec = JSONCodec
+	}
+	if o.Retry.Backoff == nil {
+		o.Retry.Backoff = ExponentialBackoff(time.Second, time.Minute)
+	}
+	return o
 }
 
+// SetHandler sets the handler of the consumer and starts it. Setting a handler again replaces
+// the previous one (of any kind) for the messages handled from then on.
 func (s *consumer) SetHandler(handler Handler) {
+	s.setHandler(handler, nil)
+	s.start()
+}
+
+// SetErrorHandler sets a handler whose returned error drives ack and retry.
+func (s *consumer) SetErrorHandler(handler ErrorHandler) {
+	s.setHandler(nil, handler)
+	s.start()
+}
+
+func (s *consumer) setHandler(handler Handler, errHandler ErrorHandler) {
+	s.handlerMu.Lock()
+	defer s.handlerMu.Unlock()
+	s.handler = handler
+	s.errHandler = errHandler
+	s.batchHandler = nil
+	s.batchSize = 1
+	s.chain.Store(s.buildHandler())
+}
+
+func (s *consumer) start() {
 	s.once.Do(func() {
+		s.limiter = s.newRateLimiter()
+		s.startWorkers()
+		if s.broadcast() {
+			s.startBroadcast()
+			return
+		}
+		if s.useStream() {
+			s.startStream()
+			return
+		}
 		s.startGetListMessage()
-		s.startGetDelayMessage()
+		s.startPromoteDelayMessage()
+		if s.options.Reliable {
+			s.startRequeueUnacked()
+		}
 	})
-	s.handler = handler
 }
 
-func (s *consumer) startGetListMessage() {
-	go func() {
-		ticker := time.NewTicker(s.options.RateLimitPeriod)
-		defer func() {
-			log.Println("stop get list message.")
-			ticker.Stop()
-		}()
-		topicName := s.topicName + listSuffix
-		for {
-			select {
-			case <-s.ctx.Done():
-				log.Printf("context Done msg: %#v \n", s.ctx.Err())
-				return
-			case <-ticker.C:
-				var revBody []byte
-				var err error
-				if !s.options.UseBLPop {
-					revBody, err = s.redisCmd.LPop(topicName).Bytes()
-				} else {
-					revs := s.redisCmd.BLPop(time.Second, topicName)
-					err = revs.Err()
-					revValues := revs.Val()
-					if len(revValues) >= 2 {
-						revBody = []byte(revValues[1])
-					}
-				}
-				if err == redis.Nil {
-					continue
-				}
-				if err != nil {
-					log.Printf("LPOP error: %#v \n", err)
-					continue
-				}
+func (s *consumer) newListMessage(raw string, level int) *Message {
+	if len(raw) == 0 {
+		return nil
+	}
+	msg := &Message{}
+	if err := decodeMessage(s.options.Codec, []byte(raw), msg); err != nil {
+		s.deadLetterUndecodable(level, raw, err)
+		return nil
+	}
+	msg.raw = raw
+	msg.topic = s.topicName
+	msg.level = level
+	msg.fetchedAt = time.Now()
+	if s.options.Reliable {
+		msg.ack = func() error { return s.ack(level, raw) }
+	}
+	return msg
+}
 
-				if len(revBody) == 0 {
-					continue
-				}
-				msg := &Message{}
-				json.Unmarshal(revBody, msg)
-				if s.handler != nil {
-					s.handler.HandleMessage(msg)
-				}
-			}
+func (s *consumer) dispatchMessages(msgs []*Message) {
+	for _, msg := range msgs {
+		s.withContext(msg)
+		defer msg.done()
+	}
+	if batch := s.handlers().batch; batch != nil {
+		s.dispatchBatch(batch, msgs)
+		return
+	}
+	for _, msg := range msgs {
+		s.dispatch(msg)
+	}
+}
+
+func (s *consumer) dispatch(msg *Message) {
+	if msg.Expired() || !s.claimDedup(msg) {
+		msg.Ack()
+		return
+	}
+	chain := s.handlers()
+	if chain.handler == nil {
+		return
+	}
+	start := time.Now()
+	err := chain.handler.HandleMessage(msg)
+	s.options.Metrics.Handled(s.topicName, 1, time.Since(start), err)
+	if chain.acks {
+		if err != nil {
+			s.releaseDedup(msg)
+			s.retryOrRelease(msg, err)
+			return
 		}
-	}()
+		s.completeDedup(msg)
+		msg.Ack()
+		return
+	}
+	if err != nil {
+		s.reportError("handle", msg.ID, err)
+		s.releaseDedup(msg)
+		return
+	}
+	s.completeDedup(msg)
 }
 
-func (s *consumer) startGetDelayMessage() {
+func (s *consumer) startGetListMessage() {
+	s.wg.Add(1)
 	go func() {
-		ticker := time.NewTicker(s.options.RateLimitPeriod)
-		defer func() {
-			log.Println("stop get delay message.")
-			ticker.Stop()
-		}()
-		topicName := s.topicName + zsetSuffix
+		defer s.wg.Done()
+		defer s.options.Logger.Printf("stop get list message.")
+		backoff := s.newPollBackoff()
 		for {
-			currentTime := time.Now().Unix()
-			select {
-			case <-s.ctx.Done():
-				log.Printf("context Done msg: %#v \n", s.ctx.Err())
+			if s.ctx.Err() != nil {
+				s.options.Logger.Printf("context Done msg: %#v \n", s.ctx.Err())
+				return
+			}
+			limit, ok := s.waitRateLimit()
+			if !ok {
 				return
-			case <-ticker.C:
-				var valuesCmd *redis.ZSliceCmd
-				_, err := s.redisCmd.TxPipelined(func(pip redis.Pipeliner) error {
-					valuesCmd = pip.ZRangeWithScores(topicName, 0, currentTime)
-					pip.ZRemRangeByScore(topicName, "0", strconv.FormatInt(currentTime, 10))
-					return nil
-				})
-				if err != nil {
-					log.Printf("zset pip error: %#v \n", err)
+			}
+			fetched, err := s.fetch(limit)
+			if err != nil {
+				if err != redis.Nil {
+					s.reportError("fetch", "", err)
+				} else if s.blockingFetch() {
+					// BLPOP already waited for a message
 					continue
 				}
-				rev := valuesCmd.Val()
-				for _, revBody := range rev {
-					msg := &Message{}
-					json.Unmarshal([]byte(revBody.Member.(string)), msg)
-					if s.handler != nil {
-						s.handler.HandleMessage(msg)
-					}
+				if !s.wait(backoff.next()) {
+					return
+				}
+				continue
+			}
+			backoff.reset()
+			s.takeRateLimit(len(fetched))
+			s.options.Metrics.Fetched(s.topicName, len(fetched))
+			msgs := make([]*Message, 0, len(fetched))
+			for _, f := range fetched {
+				if msg := s.newListMessage(f.raw, f.level); msg != nil {
+					msgs = append(msgs, msg)
 				}
 			}
+			if len(msgs) > 0 {
+				s.deliver(msgs...)
+			}
 		}
 	}()
 }
 
 type Producer struct {
 	redisCmd redis.Cmdable
+	options  ProducerOptions
 	_        struct{}
 }
 
-func NewProducer(cmd redis.Cmdable) *Producer {
-	return &Producer{redisCmd: cmd}
+func NewProducer(cmd redis.Cmdable, opts ...ProducerOption) *Producer {
+	p := &Producer{redisCmd: cmd}
+	for _, o := range opts {
+		o(&p.options)
+	}
+	if p.options.Codec == nil {
+		p.options.Codec = JSONCodec
+	}
+	if p.options.Logger == nil {
+		p.options.Logger = stdLogger{}
+	}
+	if p.options.Metrics == nil {
+		p.options.Metrics = nopMetrics{}
+	}
+	return p
 }
 
-func (p *Producer) Publish(topicName string, body []byte) error {
-	msg := NewMessage("", body)
-	sendData, _ := json.Marshal(msg)
-	return p.redisCmd.RPush(topicName+listSuffix, string(sendData)).Err()
+func (p *Producer) Publish(topicName string, body []byte, opts ...PublishOption) error {
+	msg := p.newPublishMessage(body, opts)
+	err := p.publishOnce(topicName, msg.ID, func() error {
+		return p.push(topicName, msg)
+	})
+	p.observePublish(topicName, 1, err)
+	return p.reportError("publish", topicName, msg.ID, err)
+}
+
+func (p *Producer) push(topicName string, msg *Message) error {
+	sendData, err := encodeMessage(p.options.Codec, msg)
+	if err != nil {
+		return err
+	}
+	if p.options.UseStream {
+		return p.redisCmd.XAdd(p.xaddArgs(topicName, sendData)).Err()
+	}
+	return p.redisCmd.RPush(p.listTarget(topicName, msg), sendData).Err()
 }
 
-func (p *Producer) PublishDelayMsg(topicName string, body []byte, delay time.Duration) error {
+// listTarget returns the list of the message's priority level.
+func (p *Producer) listTarget(topicName string, msg *Message) string {
+	return priorityKey(topicName+listSuffix, priorityLevel(msg.Priority, p.priorityLevels()))
+}
+
+func (p *Producer) priorityLevels() int {
+	if p.options.PriorityLevels < 1 {
+		return 1
+	}
+	return p.options.PriorityLevels
+}
+
+func (p *Producer) PublishDelayMsg(topicName string, body []byte, delay time.Duration, opts ...PublishOption) error {
 	if delay <= 0 {
 		return errors.New("delay need great than zero")
 	}
-	tm := time.Now().Add(delay)
-	msg := NewMessage("", body)
-	msg.DelayTime = tm.Unix()
-
-	sendData, _ := json.Marshal(msg)
-	return p.redisCmd.ZAdd(topicName+zsetSuffix, redis.Z{Score: float64(tm.Unix()), Member: string(sendData)}).Err()
+	_, err := p.PublishAt(topicName, body, time.Now().Add(delay), opts...)
+	return err
 }
@@ -0,0 +1,270 @@
+package redis_mq
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	streamSuffix   = ":stream"
+	streamValueKey = "msg"
+)
+
+// UseStreamGroup makes the consumer read the topic's redis stream as a member of a consumer group.
+// Every group receives a full copy of the topic, consumers in the same group share the load.
+// An empty consumerName generates a random one.
+//
+// Stream messages stay pending until Message.Ack is called. Pending messages idle for longer than
+// the visibility timeout (or the retry backoff) are claimed and delivered again. Like list messages,
+// they are moved to the dead letter list once MaxAttempts deliveries failed, by a handler error or
+// an expired visibility timeout.
+func UseStreamGroup(group, consumerName string) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.StreamGroup = group
+		o.StreamConsumer = consumerName
+	}
+}
+
+const streamBatchSize = 100
+
+func (s *consumer) useStream() bool {
+	return s.options.StreamGroup != ""
+}
+
+func (s *consumer) streamKey() string {
+	return s.topicName + streamSuffix
+}
+
+func (s *consumer) ensureStreamGroup() error {
+	err := s.redisCmd.XGroupCreateMkStream(s.streamKey(), s.options.StreamGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+func (s *consumer) startStream() {
+	if s.options.StreamConsumer == "" {
+		s.options.StreamConsumer = uuid.NewV4().String()
+	}
+	if err := s.ensureStreamGroup(); err != nil {
+		s.reportError("create stream group", "", err)
+	}
+	s.startGetStreamMessage()
+	s.startPromoteDelayMessage()
+	s.startClaimPending()
+}
+
+func (s *consumer) startGetStreamMessage() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.options.Logger.Printf("stop get stream message.")
+		backoff := s.newPollBackoff()
+		for {
+			if s.ctx.Err() != nil {
+				s.options.Logger.Printf("context Done msg: %#v \n", s.ctx.Err())
+				return
+			}
+			limit, ok := s.waitRateLimit()
+			if !ok {
+				return
+			}
+			// XREADGROUP blocks until a message arrives, so an empty stream does not need a backoff
+			streams, err := s.redisCmd.XReadGroup(&redis.XReadGroupArgs{
+				Group:    s.options.StreamGroup,
+				Consumer: s.options.StreamConsumer,
+				Streams:  []string{s.streamKey(), ">"},
+				Count:    int64(limit),
+				Block:    time.Second,
+			}).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				s.reportError("read stream", "", err)
+				if strings.HasPrefix(err.Error(), "NOGROUP") {
+					s.ensureStreamGroup()
+				}
+				if !s.wait(backoff.next()) {
+					return
+				}
+				continue
+			}
+			backoff.reset()
+			var msgs []*Message
+			for _, stream := range streams {
+				s.takeRateLimit(len(stream.Messages))
+				s.options.Metrics.Fetched(s.topicName, len(stream.Messages))
+			}
+			for _, stream := range streams {
+				for _, xmsg := range stream.Messages {
+					if msg := s.newStreamMessage(xmsg, 0); msg != nil {
+						msgs = append(msgs, msg)
+					}
+				}
+			}
+			if len(msgs) > 0 {
+				s.deliver(msgs...)
+			}
+		}
+	}()
+}
+
+func (s *consumer) newStreamMessage(xmsg redis.XMessage, attempts int) *Message {
+	raw, _ := xmsg.Values[streamValueKey].(string)
+	id := xmsg.ID
+	msg := &Message{}
+	if err := decodeMessage(s.options.Codec, []byte(raw), msg); err != nil {
+		if err := s.deadLetter("", []byte(raw), attempts, "decode: "+err.Error()); err != nil {
+			s.reportError("dead letter", "", err)
+			return nil
+		}
+		s.xack(id)
+		return nil
+	}
+	msg.Attempts = attempts
+	msg.topic = s.topicName
+	msg.fetchedAt = time.Now()
+	msg.ack = func() error { return s.xack(id) }
+	return msg
+}
+
+func (s *consumer) xack(id string) error {
+	return s.redisCmd.XAck(s.streamKey(), s.options.StreamGroup, id).Err()
+}
+
+func (s *consumer) startClaimPending() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		interval := s.options.VisibilityTimeout / 2
+		if interval > time.Second {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.claimPending(); err != nil {
+					s.reportError("claim pending", "", err)
+				}
+			}
+		}
+	}()
+}
+
+// claimPending pages through the whole pending entries list, so entries that are not idle yet
+// (waiting for a long retry backoff, or being handled) do not hide newer ones that are.
+func (s *consumer) claimPending() error {
+	start := "-"
+	for {
+		pending, err := s.redisCmd.XPendingExt(&redis.XPendingExtArgs{
+			Stream: s.streamKey(),
+			Group:  s.options.StreamGroup,
+			Start:  start,
+			End:    "+",
+			Count:  streamBatchSize,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		// the range is inclusive, the first entry of a next page was the last of the previous one
+		if start != "-" && len(pending) > 0 && pending[0].Id == start {
+			pending = pending[1:]
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := s.claimIdle(pending); err != nil {
+			return err
+		}
+		start = pending[len(pending)-1].Id
+	}
+}
+
+func (s *consumer) claimIdle(pending []redis.XPendingExt) error {
+	for _, p := range pending {
+		// RetryCount is the number of deliveries. An entry idle for longer than the visibility
+		// timeout is not being handled anymore, so each of them failed: the message is in the same
+		// state as a list message whose Attempts is RetryCount.
+		failures := int(p.RetryCount)
+		minIdle := s.options.VisibilityTimeout
+		if backoff := s.options.Retry.Backoff(failures); backoff > minIdle {
+			minIdle = backoff
+		}
+		if p.Idle < minIdle {
+			continue
+		}
+		// XCLAIM only returns the message if it is still idle, so concurrent consumers do not both get it.
+		msgs, err := s.redisCmd.XClaim(&redis.XClaimArgs{
+			Stream:   s.streamKey(),
+			Group:    s.options.StreamGroup,
+			Consumer: s.options.StreamConsumer,
+			MinIdle:  minIdle,
+			Messages: []string{p.Id},
+		}).Result()
+		if err != nil {
+			return err
+		}
+		for _, xmsg := range msgs {
+			if failures >= s.options.Retry.MaxAttempts {
+				if err := s.deadLetterStreamMessage(xmsg, failures); err != nil {
+					return err
+				}
+				continue
+			}
+			if msg := s.newStreamMessage(xmsg, failures); msg != nil {
+				s.deliver(msg)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *consumer) deadLetterStreamMessage(xmsg redis.XMessage, attempts int) error {
+	raw, _ := xmsg.Values[streamValueKey].(string)
+	msg := &Message{}
+	decodeMessage(s.options.Codec, []byte(raw), msg)
+	if err := s.deadLetter(msg.ID, []byte(raw), attempts, "max attempts exceeded"); err != nil {
+		return err
+	}
+	return s.xack(xmsg.ID)
+}
+
+type ProducerOptions struct {
+	UseStream      bool
+	StreamMaxLen   int64
+	Codec          Codec
+	PriorityLevels int
+	DedupWindow    time.Duration
+	Logger         Logger
+	OnError        func(err error)
+	Metrics        Metrics
+	Propagator     Propagator
+}
+
+type ProducerOption func(options *ProducerOptions)
+
+// UseStream makes Publish append to the topic's redis stream instead of the list,
+// to be consumed with UseStreamGroup. A maxLen > 0 trims the stream to about maxLen entries.
+func UseStream(maxLen int64) ProducerOption {
+	return func(o *ProducerOptions) {
+		o.UseStream = true
+		o.StreamMaxLen = maxLen
+	}
+}
+
+func (p *Producer) xaddArgs(topicName string, sendData string) *redis.XAddArgs {
+	return &redis.XAddArgs{
+		Stream:       topicName + streamSuffix,
+		MaxLenApprox: p.options.StreamMaxLen,
+		Values:       map[string]interface{}{streamValueKey: sendData},
+	}
+}
@@ -0,0 +1,95 @@
+package redis_mq
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeStream answers XPENDING with the pending entries from start and XCLAIM with their messages.
+type fakeStream struct {
+	pending []fakePending
+}
+
+type fakePending struct {
+	idle, count int64
+	value       string
+}
+
+func (s *fakeStream) reply(args []string) interface{} {
+	switch args[0] {
+	case "XPENDING":
+		start, n := 1, len(s.pending)
+		if args[3] != "-" {
+			start, _ = strconv.Atoi(strings.TrimSuffix(args[3], "-0"))
+		}
+		count, _ := strconv.Atoi(args[5])
+		var rev []interface{}
+		for i := start; i <= n && len(rev) < count; i++ {
+			p := s.pending[i-1]
+			rev = append(rev, []interface{}{strconv.Itoa(i) + "-0", "c", p.idle, p.count})
+		}
+		return rev
+	case "XCLAIM":
+		id := args[len(args)-1]
+		i, _ := strconv.Atoi(strings.TrimSuffix(id, "-0"))
+		return []interface{}{[]interface{}{id, []interface{}{streamValueKey, s.pending[i-1].value}}}
+	}
+	return 1
+}
+
+func TestClaimPending(t *testing.T) {
+	raw, _ := encodeMessage(JSONCodec, NewMessage("id", []byte("body")))
+	stream := &fakeStream{}
+	// a full page of entries that are not idle yet, then idle ones
+	for i := 0; i < 150; i++ {
+		stream.pending = append(stream.pending, fakePending{count: 1, value: raw})
+	}
+	idle := int64(time.Minute / time.Millisecond)
+	stream.pending = append(stream.pending,
+		fakePending{idle: idle, count: 1, value: raw},
+		fakePending{idle: idle, count: 2, value: raw},
+		fakePending{idle: idle, count: 1, value: "garbage"})
+	f, client := newFakeRedis(t, stream.reply)
+	defer f.Close()
+
+	s := NewSimpleMQConsumer(context.Background(), client, "topic", UseStreamGroup("group", "c"),
+		UseRetry(2, ExponentialBackoff(time.Millisecond, time.Millisecond)))
+	var attempts []int
+	s.setHandler(nil, HandlerFunc(func(msg *Message) error {
+		attempts = append(attempts, msg.Attempts)
+		return nil
+	}))
+	if err := s.claimPending(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.commands("XPENDING")); n != 3 {
+		t.Fatalf("%d XPENDING pages", n)
+	}
+	var claimed []string
+	for _, cmd := range f.commands("XCLAIM") {
+		claimed = append(claimed, cmd[len(cmd)-1])
+	}
+	if strings.Join(claimed, ",") != "151-0,152-0,153-0" {
+		t.Fatalf("claimed %v", claimed)
+	}
+	// 151 failed once and is delivered again
+	if len(attempts) != 1 || attempts[0] != 1 {
+		t.Fatalf("attempts %v", attempts)
+	}
+	// 152 failed MaxAttempts times and 153 can not be decoded, both are dead lettered
+	dead := f.commands("RPUSH")
+	if len(dead) != 2 || dead[0][1] != "topic:dead" || !strings.Contains(dead[0][2], "max attempts exceeded") ||
+		!strings.Contains(dead[1][2], "decode") {
+		t.Fatalf("dead %q", dead)
+	}
+	var acked []string
+	for _, cmd := range f.commands("XACK") {
+		acked = append(acked, cmd[3])
+	}
+	if strings.Join(acked, ",") != "151-0,152-0,153-0" {
+		t.Fatalf("acked %v", acked)
+	}
+}
@@ -0,0 +1,62 @@
+package redis_mq
+
+// UseWorkers runs the handler on n concurrent workers. Fetched messages are buffered in a
+// channel of bufferSize, so at most n+bufferSize messages are in flight per consumer.
+// With the default n of 1 messages are handled one by one in the order they were fetched.
+// For reliable and stream consumers the visibility timeout also covers the time spent in the buffer.
+// With a batch handler the buffer holds batches. Stream consumers always hand messages to a
+// worker, since messages claimed after the visibility timeout are delivered by another goroutine
+// than the fetched ones.
+func UseWorkers(n, bufferSize int) ConsumerOption {
+	return func(o *ConsumerOptions) {
+		o.Workers = n
+		o.BufferSize = bufferSize
+	}
+}
+
+func (s *consumer) startWorkers() {
+	workers := s.options.Workers
+	if workers <= 1 {
+		if !s.useStream() {
+			return
+		}
+		workers = 1
+	}
+	s.msgCh = make(chan []*Message, s.options.BufferSize)
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for {
+				select {
+				case <-s.ctx.Done():
+					return
+				case msgs := <-s.msgCh:
+					if s.ctx.Err() != nil {
+						s.release(msgs...)
+						return
+					}
+					s.dispatchMessages(msgs)
+				}
+			}
+		}()
+	}
+}
+
+// deliver hands fetched messages to the handler, blocking while all workers are busy and the buffer is full.
+func (s *consumer) deliver(msgs ...*Message) {
+	if s.msgCh == nil {
+		s.dispatchMessages(msgs)
+		return
+	}
+	// select picks randomly when the buffer has room, the workers may be gone already
+	if s.ctx.Err() != nil {
+		s.release(msgs...)
+		return
+	}
+	select {
+	case <-s.ctx.Done():
+		s.release(msgs...)
+	case s.msgCh <- msgs:
+	}
+}
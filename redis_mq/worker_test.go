@@ -0,0 +1,53 @@
+package redis_mq
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrency starts the workers of s with a handler that records how many messages are
+// handled at the same time, and delivers n messages from n goroutines.
+func concurrency(s Consumer, n int) int32 {
+	var running, max int32
+	var handled sync.WaitGroup
+	handled.Add(n)
+	s.setHandler(nil, HandlerFunc(func(msg *Message) error {
+		defer handled.Done()
+		r := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if r <= m || atomic.CompareAndSwapInt32(&max, m, r) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 20)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}))
+	s.startWorkers()
+	for i := 0; i < n; i++ {
+		go s.deliver(NewMessage("", nil))
+	}
+	handled.Wait()
+	s.cancel()
+	s.wg.Wait()
+	return atomic.LoadInt32(&max)
+}
+
+func TestWorkers(t *testing.T) {
+	s := NewSimpleMQConsumer(context.Background(), nil, "topic", UseWorkers(3, 3))
+	if max := concurrency(s, 6); max != 3 {
+		t.Fatalf("%d messages handled at once, want 3", max)
+	}
+}
+
+func TestStreamSingleWorker(t *testing.T) {
+	// claimed and fetched stream messages are delivered by different goroutines
+	s := NewSimpleMQConsumer(context.Background(), nil, "topic", UseStreamGroup("group", "c"))
+	if max := concurrency(s, 4); max != 1 {
+		t.Fatalf("%d messages handled at once, want 1", max)
+	}
+}